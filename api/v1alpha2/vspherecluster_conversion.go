@@ -59,7 +59,9 @@ func (src *VSphereCluster) ConvertTo(dstRaw conversion.Hub) error { // nolint
 		dst.Spec.ControlPlaneEndpoint.Port = restored.Spec.ControlPlaneEndpoint.Port
 	}
 
+	dst.Spec.FailureDomains = restored.Spec.FailureDomains
 	dst.Status.Conditions = restored.Status.Conditions
+	dst.Status.FailureDomains = restored.Status.FailureDomains
 
 	return nil
 }
@@ -134,7 +136,7 @@ func Convert_v1alpha3_CPICloudConfig_To_v1alpha2_CPICloudConfig(in *v1alpha3.CPI
 // Convert_v1alpha3_VSphereClusterStatus_To_v1alpha2_VSphereClusterStatus converts VSphereCluster.Status from v1alpha3 to v1alpha2.
 // Requires manual conversion as infrav1alpha3.VSphereClusterStatus.Conditions does not exist in VSphereClusterSpec.
 func Convert_v1alpha3_VSphereClusterStatus_To_v1alpha2_VSphereClusterStatus(in *v1alpha3.VSphereClusterStatus, out *VSphereClusterStatus, s apiconversion.Scope) error { // nolint
-	// Conditions is handled through the annotation marshalling
+	// Conditions and FailureDomains are handled through the annotation marshalling
 	out.Ready = in.Ready
 	return nil
 }
@@ -128,3 +128,19 @@ func Convert_v1alpha3_VSphereMachineStatus_To_v1alpha2_VSphereMachineStatus(in *
 
 	return nil
 }
+
+// Convert_v1alpha3_NetworkSpec_To_v1alpha2_NetworkSpec converts from the Hub version (v1alpha3) of the NetworkSpec to this version.
+func Convert_v1alpha3_NetworkSpec_To_v1alpha2_NetworkSpec(in *infrav1alpha3.NetworkSpec, out *NetworkSpec, s apiconversion.Scope) error { // nolint
+	// The Domain, DefaultSearchDomains, SkipWaitOnNetwork, Bonds, and
+	// AddressFamilyPreference fields have no v1alpha2 counterpart.
+	// They are preserved by the conversion data annotation and restored on
+	// up-conversion.
+	return autoConvert_v1alpha3_NetworkSpec_To_v1alpha2_NetworkSpec(in, out, s)
+}
+
+// Convert_v1alpha3_NetworkDeviceSpec_To_v1alpha2_NetworkDeviceSpec converts from the Hub version (v1alpha3) of the NetworkDeviceSpec to this version.
+func Convert_v1alpha3_NetworkDeviceSpec_To_v1alpha2_NetworkDeviceSpec(in *infrav1alpha3.NetworkDeviceSpec, out *NetworkDeviceSpec, s apiconversion.Scope) error { // nolint
+	// The VLAN field has no v1alpha2 counterpart. It is preserved by the
+	// conversion data annotation and restored on up-conversion.
+	return autoConvert_v1alpha3_NetworkDeviceSpec_To_v1alpha2_NetworkDeviceSpec(in, out, s)
+}
@@ -47,6 +47,14 @@ const (
 	// while installing the container storage interface  addon; those kind of errors are usually transient
 	// the operation is automatically re-tried by the controller.
 	CSIProvisioningFailedReason = "CSIProvisioningFailed"
+
+	// FailureDomainsAvailableCondition documents the status of the failure domains published by the VSphereCluster.
+	FailureDomainsAvailableCondition clusterv1.ConditionType = "FailureDomainsAvailable"
+
+	// FailureDomainsInvalidReason (Severity=Warning) documents a VSphereCluster controller detecting
+	// failure domains that cannot be published, such as one without a name; the failure domains
+	// already published are kept until the spec is fixed.
+	FailureDomainsInvalidReason = "FailureDomainsInvalid"
 )
 
 // Conditions and condition Reasons for the VSphereMachine and the VSphereVM object.
@@ -171,12 +171,58 @@ type NetworkSpec struct {
 	// +optional
 	Routes []NetworkRouteSpec `json:"routes,omitempty"`
 
+	// Domain is an optional DNS domain. When set, the hostname in the guest's
+	// metadata is rendered as the fully-qualified name HOSTNAME.DOMAIN
+	// instead of the short hostname.
+	// +optional
+	Domain string `json:"domain,omitempty"`
+
+	// DefaultSearchDomains are the DNS search domains of the devices that
+	// do not set their own SearchDomains. Setting them in a machine
+	// template applies them to every machine created from it, such as all
+	// the machines in a cluster.
+	// +optional
+	DefaultSearchDomains []string `json:"defaultSearchDomains,omitempty"`
+
+	// SkipWaitOnNetwork disables waiting for IP addresses in the guest's
+	// metadata, for devices that are assigned addresses out-of-band. By
+	// default the guest waits for an IPv4 or IPv6 address when any device
+	// has a static address or uses DHCP of that family.
+	// +optional
+	SkipWaitOnNetwork bool `json:"skipWaitOnNetwork,omitempty"`
+
+	// Bonds is a list of optional bonded interfaces that aggregate devices.
+	// +optional
+	Bonds []NetworkBondSpec `json:"bonds,omitempty"`
+
+	// AddressFamilyPreference is the IP family, IPv4 or IPv6, whose
+	// addresses, nameservers, and routes are rendered first in the guest's
+	// metadata. On dual-stack machines the first address is used as the
+	// source address and the first nameserver is queried first, so the
+	// preferred family's default route and DNS win. The other family is
+	// still rendered. By default the order of the spec is kept.
+	// +kubebuilder:validation:Enum=IPv4;IPv6
+	// +optional
+	AddressFamilyPreference AddressFamily `json:"addressFamilyPreference,omitempty"`
+
 	// PreferredAPIServeCIDR is the preferred CIDR for the Kubernetes API
-	// server endpoint on this machine
+	// server endpoint on this machine. A comma-separated list of CIDRs may
+	// be used to prefer addresses in any of several ranges.
 	// +optional
 	PreferredAPIServerCIDR string `json:"preferredAPIServerCidr,omitempty"`
 }
 
+// AddressFamily is an IP address family.
+type AddressFamily string
+
+const (
+	// AddressFamilyIPv4 is the IPv4 address family.
+	AddressFamilyIPv4 AddressFamily = "IPv4"
+
+	// AddressFamilyIPv6 is the IPv6 address family.
+	AddressFamilyIPv6 AddressFamily = "IPv6"
+)
+
 // NetworkDeviceSpec defines the network configuration for a virtual machine's
 // network device.
 type NetworkDeviceSpec struct {
@@ -243,6 +289,48 @@ type NetworkDeviceSpec struct {
 	// addresses with DNS.
 	// +optional
 	SearchDomains []string `json:"searchDomains,omitempty"`
+
+	// VLAN is the 802.1Q VLAN ID of a sub-interface layered on this device.
+	// When set, the device's addresses, gateways, routes, and nameservers
+	// are applied to the sub-interface, which is named after the device
+	// and the VLAN ID, such as eth0.100.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4094
+	// +optional
+	VLAN *int `json:"vlan,omitempty"`
+}
+
+// BondMode is the mode in which a bond balances traffic across its members.
+type BondMode string
+
+const (
+	// BondMode8023AD aggregates the members with IEEE 802.3ad dynamic link
+	// aggregation (LACP). The switch ports must be configured to match.
+	BondMode8023AD BondMode = "802.3ad"
+
+	// BondModeActiveBackup sends traffic through one member at a time and
+	// fails over to another member if it goes down.
+	BondModeActiveBackup BondMode = "active-backup"
+)
+
+// NetworkBondSpec defines a bonded interface in the guest that aggregates
+// several of the virtual machine's network devices.
+type NetworkBondSpec struct {
+	// Name is the name of the bonded interface in the guest, such as bond0.
+	Name string `json:"name"`
+
+	// Mode is the bonding mode.
+	// +kubebuilder:validation:Enum="802.3ad";active-backup
+	Mode BondMode `json:"mode"`
+
+	// Members are the names in the guest of the devices aggregated by the
+	// bond. A device is named by its DeviceName, or ethN for the Nth device
+	// if DeviceName is not set. The addresses, gateways, routes, and
+	// nameservers of the first member are applied to the bond, and the
+	// other members may not have any. In active-backup mode the first
+	// member is the primary.
+	// +kubebuilder:validation:MinItems=1
+	Members []string `json:"members"`
 }
 
 // NetworkRouteSpec defines a static network route.
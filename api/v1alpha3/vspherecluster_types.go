@@ -54,6 +54,100 @@ type VSphereClusterSpec struct {
 	// non-empty Status.Address value.
 	// +optional
 	LoadBalancerRef *corev1.ObjectReference `json:"loadBalancerRef,omitempty"`
+
+	// FailureDomains is a list of failure domains in which the cluster's
+	// machines may be placed. The failure domains are published in the
+	// VSphereCluster's status for use by Cluster API.
+	// +optional
+	FailureDomains []VSphereFailureDomain `json:"failureDomains,omitempty"`
+}
+
+// VSphereFailureDomain describes where in vSphere the machines assigned to a
+// failure domain are placed. Any empty field leaves the corresponding
+// property of the machine unchanged.
+type VSphereFailureDomain struct {
+	// Name is the name of the failure domain.
+	Name string `json:"name"`
+
+	// ControlPlane indicates whether control plane machines may be placed
+	// in the failure domain.
+	// +optional
+	ControlPlane bool `json:"controlPlane,omitempty"`
+
+	// Template is the name or inventory path of the template from which the
+	// failure domain's machines are cloned, for zones in which each site
+	// mirrors its own copy of the template.
+	// +optional
+	Template string `json:"template,omitempty"`
+
+	// Datacenter is the name or inventory path of the datacenter in which
+	// the failure domain's machines are created.
+	// +optional
+	Datacenter string `json:"datacenter,omitempty"`
+
+	// Folder is the name or inventory path of the folder in which the
+	// failure domain's machines are created.
+	// +optional
+	Folder string `json:"folder,omitempty"`
+
+	// Datastore is the name or inventory path of the datastore in which the
+	// failure domain's machines are created.
+	// +optional
+	Datastore string `json:"datastore,omitempty"`
+
+	// DatastoreCluster is the name or inventory path of the datastore
+	// cluster in which the failure domain's machines are created. The
+	// datastore within the cluster is chosen by Storage DRS when a machine
+	// is cloned. It takes precedence over Datastore.
+	// +optional
+	DatastoreCluster string `json:"datastoreCluster,omitempty"`
+
+	// ResourcePool is the name or inventory path of the resource pool in
+	// which the failure domain's machines are created. Use the inventory
+	// path, such as /dc0/host/cluster0/Resources/k8s, when compute clusters
+	// have resource pools with the same name.
+	// +optional
+	ResourcePool string `json:"resourcePool,omitempty"`
+
+	// Network is the name of the vSphere network to which the first network
+	// device of the failure domain's machines is connected, for compute
+	// clusters that use different port groups.
+	// +optional
+	Network string `json:"network,omitempty"`
+
+	// HostGroup is the name of the DRS host group to which the failure
+	// domain's machines are pinned, such as one site of a stretched
+	// cluster. It is used together with VMGroup.
+	// +optional
+	HostGroup string `json:"hostGroup,omitempty"`
+
+	// VMGroup is the name of the DRS VM group to which the failure domain's
+	// machines are added so that they may be bound to HostGroup by a
+	// VM-Host affinity rule.
+	// +optional
+	VMGroup string `json:"vmGroup,omitempty"`
+
+	// AntiAffinity requests that the failure domain's control plane
+	// machines be added to a DRS anti-affinity rule so that, where
+	// possible, they run on different hosts of the same compute cluster.
+	// +optional
+	AntiAffinity bool `json:"antiAffinity,omitempty"`
+
+	// TagSelector selects the compute cluster and datastore in which the
+	// failure domain's machines are created by a vSphere tag attached to
+	// them, such as zone=a. It is resolved when a machine is created and
+	// takes precedence over Datastore and ResourcePool.
+	// +optional
+	TagSelector *FailureDomainTagSelector `json:"tagSelector,omitempty"`
+}
+
+// FailureDomainTagSelector selects vSphere inventory objects by a tag.
+type FailureDomainTagSelector struct {
+	// Category is the name of the tag's category, such as zone.
+	Category string `json:"category"`
+
+	// Tag is the name of the tag, such as a.
+	Tag string `json:"tag"`
 }
 
 // VSphereClusterStatus defines the observed state of VSphereClusterSpec
@@ -64,6 +158,11 @@ type VSphereClusterStatus struct {
 	// Conditions defines current service state of the VSphereCluster.
 	// +optional
 	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+
+	// FailureDomains is a list of failure domain objects synced from the
+	// VSphereCluster's spec.
+	// +optional
+	FailureDomains clusterv1.FailureDomains `json:"failureDomains,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -32,6 +32,7 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/failuredomain"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/services/cloudprovider"
 	infrautilv1 "sigs.k8s.io/cluster-api-provider-vsphere/pkg/util"
@@ -245,12 +246,36 @@ func (r clusterReconciler) reconcileDelete(ctx *context.ClusterContext) (reconci
 	return reconcile.Result{}, nil
 }
 
+// reconcileFailureDomains publishes the VSphereCluster's failure domains.
+// Invalid failure domains are reported with a warning event and the
+// FailureDomainsAvailable condition so that they are visible on the
+// VSphereCluster.
+func (r clusterReconciler) reconcileFailureDomains(ctx *context.ClusterContext) error {
+	if err := failuredomain.ReconcileFailureDomain(ctx.VSphereCluster); err != nil {
+		conditions.MarkFalse(ctx.VSphereCluster, infrav1.FailureDomainsAvailableCondition, infrav1.FailureDomainsInvalidReason, clusterv1.ConditionSeverityWarning, err.Error())
+		r.Recorder.Warn(ctx.VSphereCluster, infrav1.FailureDomainsInvalidReason, err.Error())
+		return err
+	}
+	if len(ctx.VSphereCluster.Spec.FailureDomains) == 0 {
+		conditions.Delete(ctx.VSphereCluster, infrav1.FailureDomainsAvailableCondition)
+		return nil
+	}
+	conditions.MarkTrue(ctx.VSphereCluster, infrav1.FailureDomainsAvailableCondition)
+	return nil
+}
+
 func (r clusterReconciler) reconcileNormal(ctx *context.ClusterContext) (reconcile.Result, error) {
 	ctx.Logger.Info("Reconciling VSphereCluster")
 
 	// If the VSphereCluster doesn't have our finalizer, add it.
 	ctrlutil.AddFinalizer(ctx.VSphereCluster, infrav1.ClusterFinalizer)
 
+	// Publish the VSphereCluster's failure domains.
+	if err := r.reconcileFailureDomains(ctx); err != nil {
+		return reconcile.Result{}, errors.Wrapf(err,
+			"failed to reconcile failure domains for %s", ctx)
+	}
+
 	// Reconcile the VSphereCluster's load balancer.
 	if ok, err := r.reconcileLoadBalancer(ctx); !ok {
 		if err != nil {
@@ -0,0 +1,74 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	clientrecord "k8s.io/client-go/tools/record"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/context/fake"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+func TestReconcileFailureDomains(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	controllerContext := fake.NewControllerContext(fake.NewControllerManagerContext())
+	events := clientrecord.NewFakeRecorder(10)
+	controllerContext.Recorder = record.New(events)
+	ctx := fake.NewClusterContext(controllerContext)
+	r := clusterReconciler{ControllerContext: controllerContext}
+
+	// Valid failure domains are published.
+	ctx.VSphereCluster.Spec.FailureDomains = []infrav1.VSphereFailureDomain{
+		{Name: "zone-a", Datastore: "datastore-a"},
+	}
+	g.Expect(r.reconcileFailureDomains(ctx)).To(gomega.Succeed())
+	g.Expect(ctx.VSphereCluster.Status.FailureDomains).To(gomega.HaveKey("zone-a"))
+	g.Expect(conditions.IsTrue(ctx.VSphereCluster, infrav1.FailureDomainsAvailableCondition)).To(gomega.BeTrue())
+	g.Expect(events.Events).To(gomega.BeEmpty())
+
+	// Invalid failure domains are reported on the VSphereCluster, and the
+	// published failure domains are kept.
+	ctx.VSphereCluster.Spec.FailureDomains = []infrav1.VSphereFailureDomain{
+		{Name: "zone-a", Datastore: "datastore-a"},
+		{Name: "zone-a", Datastore: "datastore-b"},
+	}
+	g.Expect(r.reconcileFailureDomains(ctx)).NotTo(gomega.Succeed())
+	g.Expect(ctx.VSphereCluster.Status.FailureDomains).To(gomega.HaveKey("zone-a"))
+	condition := conditions.Get(ctx.VSphereCluster, infrav1.FailureDomainsAvailableCondition)
+	g.Expect(condition).NotTo(gomega.BeNil())
+	g.Expect(condition.Status).To(gomega.Equal(corev1.ConditionFalse))
+	g.Expect(condition.Reason).To(gomega.Equal(infrav1.FailureDomainsInvalidReason))
+	g.Expect(condition.Severity).To(gomega.Equal(clusterv1.ConditionSeverityWarning))
+	g.Expect(condition.Message).To(gomega.ContainSubstring(`failure domain "zone-a" is defined more than once`))
+	g.Expect(events.Events).To(gomega.Receive(gomega.And(
+		gomega.ContainSubstring(corev1.EventTypeWarning),
+		gomega.ContainSubstring(infrav1.FailureDomainsInvalidReason))))
+
+	// The condition is removed once the VSphereCluster has no failure
+	// domains.
+	ctx.VSphereCluster.Spec.FailureDomains = nil
+	g.Expect(r.reconcileFailureDomains(ctx)).To(gomega.Succeed())
+	g.Expect(conditions.Has(ctx.VSphereCluster, infrav1.FailureDomainsAvailableCondition)).To(gomega.BeFalse())
+}
@@ -48,7 +48,9 @@ import (
 
 	kerrors "k8s.io/apimachinery/pkg/util/errors"
 	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/constants"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/failuredomain"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
 	infrautilv1 "sigs.k8s.io/cluster-api-provider-vsphere/pkg/util"
 )
@@ -436,30 +438,25 @@ func (r machineReconciler) reconcileNormalPre7(ctx *context.MachineContext, vsph
 		// clone spec.
 		ctx.VSphereMachine.Spec.VirtualMachineCloneSpec.DeepCopyInto(&vm.Spec.VirtualMachineCloneSpec)
 
-		// Several of the VSphereVM's clone spec properties can be derived
-		// from multiple places. The order is:
-		//
-		//   1. From the VSphereMachine.Spec (the DeepCopyInto above)
-		//   2. From the VSphereCluster.Spec.CloudProviderConfiguration.Workspace
-		//   3. From the VSphereCluster.Spec
-		vsphereCloudConfig := ctx.VSphereCluster.Spec.CloudProviderConfiguration.Workspace
-		if vm.Spec.Server == "" {
-			if vm.Spec.Server = vsphereCloudConfig.Server; vm.Spec.Server == "" {
-				vm.Spec.Server = ctx.VSphereCluster.Spec.Server
+		// Place the VSphereVM in the Machine's failure domain, if any.
+		delete(vm.Annotations, constants.FailureDomainAnnotationLabel)
+		if fd := ctx.Machine.Spec.FailureDomain; fd != nil {
+			applied, overrides, err := failuredomain.UpdateVSphereVMFromFailureDomain(ctx.Logger, vm, ctx.VSphereCluster.Status.FailureDomains, *fd)
+			if err != nil {
+				return errors.Wrapf(err, "failed to place VSphereVM %s/%s in failure domain %q", vm.Namespace, vm.Name, *fd)
+			}
+			if !applied {
+				ctx.Logger.Info("failure domain not found", "failure-domain", *fd)
+			} else {
+				if vm.Annotations == nil {
+					vm.Annotations = map[string]string{}
+				}
+				vm.Annotations[constants.FailureDomainAnnotationLabel] = *fd
+				ctx.Logger.V(4).Info("placed VSphereVM in failure domain", "failure-domain", *fd, "overrides", overrides)
 			}
 		}
-		if vm.Spec.Datacenter == "" {
-			vm.Spec.Datacenter = vsphereCloudConfig.Datacenter
-		}
-		if vm.Spec.Datastore == "" {
-			vm.Spec.Datastore = vsphereCloudConfig.Datastore
-		}
-		if vm.Spec.Folder == "" {
-			vm.Spec.Folder = vsphereCloudConfig.Folder
-		}
-		if vm.Spec.ResourcePool == "" {
-			vm.Spec.ResourcePool = vsphereCloudConfig.ResourcePool
-		}
+
+		setVSphereVMPlacementDefaults(vm, ctx.VSphereCluster)
 		if vsphereVM != nil {
 			vm.Spec.BiosUUID = vsphereVM.Spec.BiosUUID
 		}
@@ -478,6 +475,38 @@ func (r machineReconciler) reconcileNormalPre7(ctx *context.MachineContext, vsph
 	return vm, nil
 }
 
+// setVSphereVMPlacementDefaults fills in the placement fields of the
+// VSphereVM's clone spec that are not already set. Several of them can be
+// derived from multiple places. The order is:
+//
+//  1. From the Machine's failure domain
+//  2. From the VSphereMachine.Spec
+//  3. From the VSphereCluster.Spec.CloudProviderConfiguration.Workspace
+//  4. From the VSphereCluster.Spec
+//
+// The datastore is left empty if the failure domain placed the VSphereVM in
+// a datastore cluster, as Storage DRS chooses it when the VM is cloned.
+func setVSphereVMPlacementDefaults(vm *infrav1.VSphereVM, vsphereCluster *infrav1.VSphereCluster) {
+	vsphereCloudConfig := vsphereCluster.Spec.CloudProviderConfiguration.Workspace
+	if vm.Spec.Server == "" {
+		if vm.Spec.Server = vsphereCloudConfig.Server; vm.Spec.Server == "" {
+			vm.Spec.Server = vsphereCluster.Spec.Server
+		}
+	}
+	if vm.Spec.Datacenter == "" {
+		vm.Spec.Datacenter = vsphereCloudConfig.Datacenter
+	}
+	if vm.Spec.Datastore == "" && vm.Annotations[constants.DatastoreClusterAnnotationLabel] == "" {
+		vm.Spec.Datastore = vsphereCloudConfig.Datastore
+	}
+	if vm.Spec.Folder == "" {
+		vm.Spec.Folder = vsphereCloudConfig.Folder
+	}
+	if vm.Spec.ResourcePool == "" {
+		vm.Spec.ResourcePool = vsphereCloudConfig.ResourcePool
+	}
+}
+
 func (r machineReconciler) reconcileNetwork(ctx *context.MachineContext, vm *unstructured.Unstructured) (bool, error) {
 	var errs []error
 	if networkStatusListOfIfaces, ok, _ := unstructured.NestedSlice(vm.Object, "status", "network"); ok {
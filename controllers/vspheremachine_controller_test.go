@@ -0,0 +1,100 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/constants"
+)
+
+func TestSetVSphereVMPlacementDefaults(t *testing.T) {
+	vsphereCluster := &infrav1.VSphereCluster{
+		Spec: infrav1.VSphereClusterSpec{
+			Server: "cluster-server",
+			CloudProviderConfiguration: infrav1.CPIConfig{
+				Workspace: infrav1.CPIWorkspaceConfig{
+					Datacenter:   "workspace-dc",
+					Datastore:    "workspace-datastore",
+					Folder:       "workspace-folder",
+					ResourcePool: "workspace-pool",
+				},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		spec        infrav1.VirtualMachineCloneSpec
+		expected    infrav1.VirtualMachineCloneSpec
+	}{
+		{
+			name: "workspace fills unset fields",
+			expected: infrav1.VirtualMachineCloneSpec{
+				Server:       "cluster-server",
+				Datacenter:   "workspace-dc",
+				Datastore:    "workspace-datastore",
+				Folder:       "workspace-folder",
+				ResourcePool: "workspace-pool",
+			},
+		},
+		{
+			name: "spec takes precedence over workspace",
+			spec: infrav1.VirtualMachineCloneSpec{
+				Server:    "vm-server",
+				Datastore: "vm-datastore",
+			},
+			expected: infrav1.VirtualMachineCloneSpec{
+				Server:       "vm-server",
+				Datacenter:   "workspace-dc",
+				Datastore:    "vm-datastore",
+				Folder:       "workspace-folder",
+				ResourcePool: "workspace-pool",
+			},
+		},
+		{
+			name: "datastore cluster leaves the datastore to Storage DRS",
+			annotations: map[string]string{
+				constants.DatastoreClusterAnnotationLabel: "sdrs-a",
+			},
+			expected: infrav1.VirtualMachineCloneSpec{
+				Server:       "cluster-server",
+				Datacenter:   "workspace-dc",
+				Folder:       "workspace-folder",
+				ResourcePool: "workspace-pool",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+			vm := &infrav1.VSphereVM{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+				Spec:       infrav1.VSphereVMSpec{VirtualMachineCloneSpec: tc.spec},
+			}
+			setVSphereVMPlacementDefaults(vm, vsphereCluster)
+			g.Expect(vm.Spec.VirtualMachineCloneSpec).To(gomega.Equal(tc.expected))
+		})
+	}
+}
@@ -45,7 +45,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/constants"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/failuredomain"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/services"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/services/govmomi"
@@ -137,9 +139,7 @@ func (r vmReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, reterr error)
 	}
 
 	// Get or create an authenticated session to the vSphere endpoint.
-	authSession, err := session.GetOrCreate(r.Context,
-		vsphereVM.Spec.Server, vsphereVM.Spec.Datacenter,
-		r.ControllerManagerContext.Username, r.ControllerManagerContext.Password)
+	authSession, err := session.GetOrCreate(r.Context, r.sessionParams(vsphereVM))
 	if err != nil {
 		return reconcile.Result{}, errors.Wrap(err, "failed to create vSphere session")
 	}
@@ -280,6 +280,20 @@ func (r vmReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, reterr error)
 	return r.reconcileNormal(vmContext)
 }
 
+// sessionParams returns the parameters of the session used to reconcile the
+// VSphereVM. The server is verified as configured for the controller
+// manager.
+func (r vmReconciler) sessionParams(vsphereVM *infrav1.VSphereVM) *session.Params {
+	return session.NewParams().
+		WithServer(vsphereVM.Spec.Server).
+		WithDatacenter(vsphereVM.Spec.Datacenter).
+		WithInsecure(r.ControllerManagerContext.Insecure).
+		WithThumbprint(r.ControllerManagerContext.Thumbprint).
+		WithCACerts(r.ControllerManagerContext.CACerts).
+		WithUserInfo(r.ControllerManagerContext.Username, r.ControllerManagerContext.Password).
+		WithLogger(r.Logger)
+}
+
 func (r vmReconciler) reconcileDelete(ctx *context.VMContext) (reconcile.Result, error) {
 	ctx.Logger.Info("Handling deleted VSphereVM")
 
@@ -322,6 +336,25 @@ func (r vmReconciler) reconcileNormal(ctx *context.VMContext) (reconcile.Result,
 		return reconcile.Result{}, nil
 	}
 
+	// Resolve the placement selected by the failure domain's tag, and check
+	// that a resource pool set by a failure domain is not ambiguous, before
+	// the VM is created. The resolved placement is recorded on the
+	// VSphereVM, and neither is repeated while the clone task is in flight.
+	if ctx.VSphereVM.Spec.BiosUUID == "" && ctx.VSphereVM.Status.TaskRef == "" {
+		if err := failuredomain.ResolveTagSelector(ctx, ctx.Session, ctx.VSphereVM); err != nil {
+			return reconcile.Result{}, errors.Wrapf(err, "failed to resolve failure domain tag selector")
+		}
+		if _, ok := ctx.VSphereVM.Annotations[constants.FailureDomainAnnotationLabel]; ok {
+			cluster, err := clusterutilv1.GetClusterFromMetadata(ctx, ctx.Client, ctx.VSphereVM.ObjectMeta)
+			if err != nil {
+				return reconcile.Result{}, errors.Wrapf(err, "failed to get the cluster of the failure domain")
+			}
+			if err := failuredomain.ValidateResourcePool(ctx, ctx.Session, ctx.VSphereVM, cluster.Status.FailureDomains); err != nil {
+				return reconcile.Result{}, errors.Wrapf(err, "invalid resource pool")
+			}
+		}
+	}
+
 	// Get or create the VM.
 	vm, err := vmService.ReconcileVM(ctx)
 	if err != nil {
@@ -0,0 +1,106 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/soap"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/context/fake"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/session"
+)
+
+func TestVMReconcilerSessionParams(t *testing.T) {
+	model := simulator.VPX()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	defer model.Remove()
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	cert := server.Certificate()
+	pass, _ := server.URL.User.Password()
+
+	testCases := []struct {
+		name        string
+		insecure    bool
+		thumbprint  string
+		caCerts     []byte
+		expectError bool
+	}{
+		{
+			// The simulator's self-signed certificate is not trusted by
+			// the system roots.
+			name:        "system roots verify the server",
+			expectError: true,
+		},
+		{
+			name:     "insecure",
+			insecure: true,
+		},
+		{
+			name:       "thumbprint verifies the server",
+			thumbprint: soap.ThumbprintSHA1(cert),
+		},
+		{
+			name:        "mismatched thumbprint is not skipped by insecure",
+			insecure:    true,
+			thumbprint:  "00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00",
+			expectError: true,
+		},
+		{
+			name:    "CA certs verify the server",
+			caCerts: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+			controllerManagerContext := fake.NewControllerManagerContext()
+			controllerManagerContext.Username = server.URL.User.Username()
+			controllerManagerContext.Password = pass
+			controllerManagerContext.Insecure = tc.insecure
+			controllerManagerContext.Thumbprint = tc.thumbprint
+			controllerManagerContext.CACerts = tc.caCerts
+			r := vmReconciler{ControllerContext: fake.NewControllerContext(controllerManagerContext)}
+
+			params := r.sessionParams(&infrav1.VSphereVM{
+				Spec: infrav1.VSphereVMSpec{
+					VirtualMachineCloneSpec: infrav1.VirtualMachineCloneSpec{
+						Server: server.URL.Host,
+					},
+				},
+			})
+			_, err := session.GetOrCreate(controllerManagerContext, params)
+			if tc.expectError {
+				g.Expect(err).To(gomega.HaveOccurred())
+				return
+			}
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			session.Evict(params)
+		})
+	}
+}
@@ -117,6 +117,24 @@ func main() {
 		"/etc/capv/credentials.yaml",
 		"path to CAPV's credentials file",
 	)
+	flag.BoolVar(
+		&managerOpts.Insecure,
+		"insecure",
+		false,
+		"Skip verifying the certificates of vSphere endpoints when neither a thumbprint nor CA certificates are given. By default they are verified against the system's trusted roots.",
+	)
+	flag.StringVar(
+		&managerOpts.Thumbprint,
+		"thumbprint",
+		"",
+		"SHA-1 thumbprint used to verify the certificates of vSphere endpoints",
+	)
+	flag.StringVar(
+		&managerOpts.CACertsFile,
+		"ca-certs-file",
+		"",
+		"path to a PEM-encoded bundle of CA certificates used to verify the certificates of vSphere endpoints",
+	)
 
 	flag.Parse()
 
@@ -126,6 +144,16 @@ func main() {
 			"namespace", managerOpts.WatchNamespace)
 	}
 
+	if managerOpts.Thumbprint == "" && managerOpts.CACertsFile == "" {
+		if managerOpts.Insecure {
+			setupLog.Info("WARNING: the certificates of vSphere endpoints are not verified")
+		} else {
+			setupLog.Info(
+				"Verifying the certificates of vSphere endpoints against the system's trusted roots; " +
+					"use --thumbprint or --ca-certs-file for endpoints with self-signed certificates")
+		}
+	}
+
 	if *profilerAddress != "" {
 		setupLog.Info(
 			"Profiler listening for requests",
@@ -48,4 +48,50 @@ const (
 	// MaintenanceAnnotationLabel is the annotation used to indicate a machine and/or
 	// cluster are in maintenance mode.
 	MaintenanceAnnotationLabel = "capv." + v1alpha3.GroupName + "/maintenance"
+
+	// MetadataAnnotationPrefix is the prefix of the annotations whose values
+	// are added to a VSphereVM's cloud-init metadata. The rest of each
+	// annotation's name is the metadata key, such as availability-zone.
+	MetadataAnnotationPrefix = "metadata.capv." + v1alpha3.GroupName + "/"
+
+	// FailureDomainAnnotationLabel is the annotation used to record the name
+	// of the failure domain in which a VSphereVM is placed.
+	FailureDomainAnnotationLabel = "capv." + v1alpha3.GroupName + "/failure-domain"
+
+	// HostGroupAnnotationLabel is the annotation used to record the DRS host
+	// group to which a VSphereVM is pinned by its failure domain.
+	HostGroupAnnotationLabel = "capv." + v1alpha3.GroupName + "/host-group"
+
+	// VMGroupAnnotationLabel is the annotation used to record the DRS VM
+	// group to which a VSphereVM is added by its failure domain.
+	VMGroupAnnotationLabel = "capv." + v1alpha3.GroupName + "/vm-group"
+
+	// AntiAffinityGroupAnnotationLabel is the annotation used to record the
+	// name of the DRS anti-affinity rule to which a control plane VM is
+	// added, as requested by its failure domain.
+	AntiAffinityGroupAnnotationLabel = "capv." + v1alpha3.GroupName + "/anti-affinity-group"
+
+	// DatastoreClusterAnnotationLabel is the annotation used to record the
+	// datastore cluster in which a VSphereVM is placed by Storage DRS, as
+	// set by its failure domain.
+	DatastoreClusterAnnotationLabel = "capv." + v1alpha3.GroupName + "/datastore-cluster"
+
+	// TagCategoryAnnotationLabel is the annotation used to record the
+	// category of the tag that selects a VSphereVM's placement by its
+	// failure domain.
+	TagCategoryAnnotationLabel = "capv." + v1alpha3.GroupName + "/tag-category"
+
+	// TagAnnotationLabel is the annotation used to record the name of the
+	// tag that selects a VSphereVM's placement by its failure domain.
+	TagAnnotationLabel = "capv." + v1alpha3.GroupName + "/tag"
+
+	// TagDatastoreAnnotationLabel is the annotation used to record the
+	// datastore resolved from the tag that selects a VSphereVM's placement,
+	// so that the tag is resolved once.
+	TagDatastoreAnnotationLabel = "capv." + v1alpha3.GroupName + "/tag-datastore"
+
+	// TagResourcePoolAnnotationLabel is the annotation used to record the
+	// resource pool resolved from the tag that selects a VSphereVM's
+	// placement, so that the tag is resolved once.
+	TagResourcePoolAnnotationLabel = "capv." + v1alpha3.GroupName + "/tag-resource-pool"
 )
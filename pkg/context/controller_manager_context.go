@@ -77,6 +77,18 @@ type ControllerManagerContext struct {
 	// endpoints.
 	Password string
 
+	// Insecure skips verifying the certificates of remote vSphere endpoints
+	// when neither Thumbprint nor CACerts is set.
+	Insecure bool
+
+	// Thumbprint is the SHA-1 thumbprint used to verify the certificates of
+	// remote vSphere endpoints.
+	Thumbprint string
+
+	// CACerts is a PEM-encoded bundle of CA certificates used to verify the
+	// certificates of remote vSphere endpoints.
+	CACerts []byte
+
 	genericEventCache sync.Map
 }
 
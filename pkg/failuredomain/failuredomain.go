@@ -0,0 +1,340 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package failuredomain
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/constants"
+)
+
+const (
+	// FailureDomainKeyTemplate is the failure domain attribute that holds
+	// the template from which machines are cloned.
+	FailureDomainKeyTemplate = "template"
+
+	// FailureDomainKeyDatacenter is the failure domain attribute that holds
+	// the datacenter in which machines are created.
+	FailureDomainKeyDatacenter = "datacenter"
+
+	// FailureDomainKeyFolder is the failure domain attribute that holds the
+	// folder in which machines are created.
+	FailureDomainKeyFolder = "folder"
+
+	// FailureDomainKeyDatastore is the failure domain attribute that holds
+	// the datastore in which machines are created.
+	FailureDomainKeyDatastore = "datastore"
+
+	// FailureDomainKeyDatastoreCluster is the failure domain attribute that
+	// holds the datastore cluster in which machines are created.
+	FailureDomainKeyDatastoreCluster = "datastoreCluster"
+
+	// FailureDomainKeyResourcePool is the failure domain attribute that
+	// holds the resource pool in which machines are created.
+	FailureDomainKeyResourcePool = "resourcePool"
+
+	// FailureDomainKeyNetwork is the failure domain attribute that holds the
+	// network to which the first network device of machines is connected.
+	FailureDomainKeyNetwork = "network"
+
+	// FailureDomainKeyHostGroup is the failure domain attribute that holds
+	// the DRS host group to which machines are pinned.
+	FailureDomainKeyHostGroup = "hostGroup"
+
+	// FailureDomainKeyVMGroup is the failure domain attribute that holds
+	// the DRS VM group to which machines are added.
+	FailureDomainKeyVMGroup = "vmGroup"
+
+	// FailureDomainKeyAntiAffinity is the failure domain attribute that is
+	// "true" when control plane machines are kept on different hosts by a
+	// DRS anti-affinity rule.
+	FailureDomainKeyAntiAffinity = "antiAffinity"
+
+	// FailureDomainKeyTagCategory is the failure domain attribute that
+	// holds the category of the tag that selects where machines are
+	// created.
+	FailureDomainKeyTagCategory = "tagCategory"
+
+	// FailureDomainKeyTag is the failure domain attribute that holds the
+	// name of the tag that selects where machines are created.
+	FailureDomainKeyTag = "tag"
+)
+
+// GetFailureDomain returns the Cluster API representation of a
+// VSphereFailureDomain. Only the non-empty placement fields are recorded
+// as attributes.
+func GetFailureDomain(fd infrav1.VSphereFailureDomain) clusterv1.FailureDomainSpec {
+	attributes := map[string]string{}
+	setAttribute(attributes, FailureDomainKeyTemplate, fd.Template)
+	setAttribute(attributes, FailureDomainKeyDatacenter, fd.Datacenter)
+	setAttribute(attributes, FailureDomainKeyFolder, fd.Folder)
+	setAttribute(attributes, FailureDomainKeyDatastore, fd.Datastore)
+	setAttribute(attributes, FailureDomainKeyDatastoreCluster, fd.DatastoreCluster)
+	setAttribute(attributes, FailureDomainKeyResourcePool, fd.ResourcePool)
+	setAttribute(attributes, FailureDomainKeyNetwork, fd.Network)
+	setAttribute(attributes, FailureDomainKeyHostGroup, fd.HostGroup)
+	setAttribute(attributes, FailureDomainKeyVMGroup, fd.VMGroup)
+	if fd.AntiAffinity {
+		setAttribute(attributes, FailureDomainKeyAntiAffinity, "true")
+	}
+	if fd.TagSelector != nil {
+		setAttribute(attributes, FailureDomainKeyTagCategory, fd.TagSelector.Category)
+		setAttribute(attributes, FailureDomainKeyTag, fd.TagSelector.Tag)
+	}
+	if len(attributes) == 0 {
+		attributes = nil
+	}
+	return clusterv1.FailureDomainSpec{
+		ControlPlane: fd.ControlPlane,
+		Attributes:   attributes,
+	}
+}
+
+// SetFailureDomain returns the VSphereFailureDomain with the given name
+// that is represented by a Cluster API failure domain. It is the inverse of
+// GetFailureDomain.
+func SetFailureDomain(name string, spec clusterv1.FailureDomainSpec) infrav1.VSphereFailureDomain {
+	fd := infrav1.VSphereFailureDomain{
+		Name:             name,
+		ControlPlane:     spec.ControlPlane,
+		Template:         spec.Attributes[FailureDomainKeyTemplate],
+		Datacenter:       spec.Attributes[FailureDomainKeyDatacenter],
+		Folder:           spec.Attributes[FailureDomainKeyFolder],
+		Datastore:        spec.Attributes[FailureDomainKeyDatastore],
+		DatastoreCluster: spec.Attributes[FailureDomainKeyDatastoreCluster],
+		ResourcePool:     spec.Attributes[FailureDomainKeyResourcePool],
+		Network:          spec.Attributes[FailureDomainKeyNetwork],
+		HostGroup:        spec.Attributes[FailureDomainKeyHostGroup],
+		VMGroup:          spec.Attributes[FailureDomainKeyVMGroup],
+		AntiAffinity:     spec.Attributes[FailureDomainKeyAntiAffinity] == "true",
+	}
+	category, tag := spec.Attributes[FailureDomainKeyTagCategory], spec.Attributes[FailureDomainKeyTag]
+	if category != "" || tag != "" {
+		fd.TagSelector = &infrav1.FailureDomainTagSelector{
+			Category: category,
+			Tag:      tag,
+		}
+	}
+	return fd
+}
+
+// ComputeFailureDomains returns the Cluster API failure domains that
+// represent the given VSphereFailureDomains, keyed by name, without
+// modifying any object. It returns nil if fds is empty, and an error if a
+// failure domain has no name or shares its name with another.
+func ComputeFailureDomains(fds []infrav1.VSphereFailureDomain) (clusterv1.FailureDomains, error) {
+	if len(fds) == 0 {
+		return nil, nil
+	}
+	computed := make(clusterv1.FailureDomains, len(fds))
+	for i, fd := range fds {
+		if fd.Name == "" {
+			return nil, errors.Errorf("failure domain %d has no name", i)
+		}
+		if _, ok := computed[fd.Name]; ok {
+			return nil, errors.Errorf("failure domain %q is defined more than once", fd.Name)
+		}
+		computed[fd.Name] = GetFailureDomain(fd)
+	}
+	return computed, nil
+}
+
+// ReconcileFailureDomain publishes the failure domains in the
+// VSphereCluster's spec to its status. The status is left unchanged if the
+// failure domains are invalid.
+func ReconcileFailureDomain(vsphereCluster *infrav1.VSphereCluster) error {
+	fds, err := ComputeFailureDomains(vsphereCluster.Spec.FailureDomains)
+	if err != nil {
+		return err
+	}
+	vsphereCluster.Status.FailureDomains = fds
+	return nil
+}
+
+// FailureDomainsToAnnotation returns the JSON form of the VSphereFailureDomains
+// represented by the given Cluster API failure domains, sorted by name. It
+// is the inverse of ComputeFailureDomains and is meant for tooling that
+// copies a VSphereCluster's failure domains between objects, such as an
+// annotation used during migration. An empty string is returned if fds is
+// empty.
+func FailureDomainsToAnnotation(fds clusterv1.FailureDomains) (string, error) {
+	if len(fds) == 0 {
+		return "", nil
+	}
+	names := make([]string, 0, len(fds))
+	for name := range fds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	vsphereFDs := make([]infrav1.VSphereFailureDomain, 0, len(names))
+	for _, name := range names {
+		vsphereFDs = append(vsphereFDs, SetFailureDomain(name, fds[name]))
+	}
+	data, err := json.Marshal(vsphereFDs)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshalling failure domains")
+	}
+	return string(data), nil
+}
+
+// UpdateVSphereVMFromFailureDomain overrides the placement of the VSphereVM
+// with the attributes of the named failure domain. Attributes that the
+// failure domain does not set leave the VSphereVM unchanged, and DRS groups,
+// datastore clusters and tag selectors are recorded as annotations. The
+// logger records which source wins for each placement field.
+//
+// applied reports whether the named failure domain is in fds, and overrides
+// maps the key of each applied attribute to its value. An error is returned,
+// and the VSphereVM is left unchanged, if the failure domain sets a tag
+// category without a tag.
+func UpdateVSphereVMFromFailureDomain(logger logr.Logger, vm *infrav1.VSphereVM, fds clusterv1.FailureDomains, name string) (applied bool, overrides map[string]string, err error) {
+	fd, ok := fds[name]
+	if !ok {
+		return false, nil, nil
+	}
+	if fd.Attributes[FailureDomainKeyTagCategory] != "" && fd.Attributes[FailureDomainKeyTag] == "" {
+		return true, nil, errors.Errorf("failure domain %q has a tag category but no tag", name)
+	}
+
+	logger = logger.WithValues("failure-domain", name)
+	overrides = map[string]string{}
+	overrideFromAttribute(logger, &vm.Spec.Template, overrides, fd.Attributes, FailureDomainKeyTemplate)
+	overrideFromAttribute(logger, &vm.Spec.Datacenter, overrides, fd.Attributes, FailureDomainKeyDatacenter)
+	overrideFromAttribute(logger, &vm.Spec.Folder, overrides, fd.Attributes, FailureDomainKeyFolder)
+	overrideFromAttribute(logger, &vm.Spec.Datastore, overrides, fd.Attributes, FailureDomainKeyDatastore)
+	overrideFromAttribute(logger, &vm.Spec.ResourcePool, overrides, fd.Attributes, FailureDomainKeyResourcePool)
+	if len(vm.Spec.Network.Devices) > 0 {
+		overrideFromAttribute(logger, &vm.Spec.Network.Devices[0].NetworkName, overrides, fd.Attributes, FailureDomainKeyNetwork)
+	}
+	if fd.Attributes[FailureDomainKeyDatastoreCluster] != "" {
+		if vm.Spec.Datastore != "" {
+			logger.V(4).Info("failure domain datastore cluster clears the datastore",
+				"field", FailureDomainKeyDatastore, "datastore", vm.Spec.Datastore,
+				"datastoreCluster", fd.Attributes[FailureDomainKeyDatastoreCluster])
+		}
+		vm.Spec.Datastore = ""
+		overrides[FailureDomainKeyDatastore] = ""
+		annotateFromAttribute(vm, overrides, constants.DatastoreClusterAnnotationLabel, fd.Attributes, FailureDomainKeyDatastoreCluster)
+	} else {
+		delete(vm.Annotations, constants.DatastoreClusterAnnotationLabel)
+	}
+	annotateFromAttribute(vm, overrides, constants.HostGroupAnnotationLabel, fd.Attributes, FailureDomainKeyHostGroup)
+	annotateFromAttribute(vm, overrides, constants.VMGroupAnnotationLabel, fd.Attributes, FailureDomainKeyVMGroup)
+	if vm.Annotations[constants.TagCategoryAnnotationLabel] != fd.Attributes[FailureDomainKeyTagCategory] ||
+		vm.Annotations[constants.TagAnnotationLabel] != fd.Attributes[FailureDomainKeyTag] {
+		for _, annotation := range tagAnnotations {
+			delete(vm.Annotations, annotation)
+		}
+	}
+	annotateFromAttribute(vm, overrides, constants.TagCategoryAnnotationLabel, fd.Attributes, FailureDomainKeyTagCategory)
+	annotateFromAttribute(vm, overrides, constants.TagAnnotationLabel, fd.Attributes, FailureDomainKeyTag)
+	applyTagPlacement(vm)
+	if fd.Attributes[FailureDomainKeyAntiAffinity] == "true" {
+		if group := antiAffinityGroup(vm); group != "" {
+			if vm.Annotations == nil {
+				vm.Annotations = map[string]string{}
+			}
+			vm.Annotations[constants.AntiAffinityGroupAnnotationLabel] = group
+			overrides[FailureDomainKeyAntiAffinity] = group
+		}
+	}
+	return true, overrides, nil
+}
+
+// antiAffinityGroup returns the name of the DRS anti-affinity rule shared by
+// the control plane VMs of the VSphereVM's cluster, or an empty string if
+// the VSphereVM is not part of a control plane.
+func antiAffinityGroup(vm *infrav1.VSphereVM) string {
+	if _, ok := vm.Labels[clusterv1.MachineControlPlaneLabelName]; !ok {
+		return ""
+	}
+	clusterName := vm.Labels[clusterv1.ClusterLabelName]
+	if clusterName == "" {
+		return ""
+	}
+	return vm.Namespace + "-" + clusterName + "-control-plane"
+}
+
+func setAttribute(attributes map[string]string, key, value string) {
+	if value != "" {
+		attributes[key] = value
+	}
+}
+
+func annotateFromAttribute(vm *infrav1.VSphereVM, overrides map[string]string, annotation string, attributes map[string]string, key string) {
+	value := attributes[key]
+	if value == "" {
+		return
+	}
+	if vm.Annotations == nil {
+		vm.Annotations = map[string]string{}
+	}
+	vm.Annotations[annotation] = value
+	overrides[key] = value
+}
+
+func overrideFromAttribute(logger logr.Logger, field *string, overrides map[string]string, attributes map[string]string, key string) {
+	spec, value := *field, attributes[key]
+	if spec == "" && value == "" {
+		return
+	}
+	source := "spec"
+	if value != "" {
+		source = "failureDomain"
+		*field = value
+		overrides[key] = value
+	}
+	logger.V(4).Info("resolved VSphereVM placement field",
+		"field", key, "spec", spec, "failureDomain", value, "source", source)
+}
+
+// PickFailureDomain returns the name of the failure domain in fds that is
+// used the least by the failure domains in existing. Ties are broken by
+// choosing the name that sorts first so that the result is deterministic.
+// An empty string is returned if fds is empty.
+func PickFailureDomain(fds clusterv1.FailureDomains, existing []string) string {
+	if len(fds) == 0 {
+		return ""
+	}
+
+	counts := make(map[string]int, len(fds))
+	for _, name := range existing {
+		if _, ok := fds[name]; ok {
+			counts[name]++
+		}
+	}
+
+	names := make([]string, 0, len(fds))
+	for name := range fds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	picked := names[0]
+	for _, name := range names[1:] {
+		if counts[name] < counts[picked] {
+			picked = name
+		}
+	}
+	return picked
+}
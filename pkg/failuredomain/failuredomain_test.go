@@ -0,0 +1,745 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package failuredomain_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-logr/logr"
+	logrtesting "github.com/go-logr/logr/testing"
+	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/constants"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/failuredomain"
+)
+
+func TestGetSetFailureDomainRoundTrip(t *testing.T) {
+	testCases := []infrav1.VSphereFailureDomain{
+		{
+			Name: "empty",
+		},
+		{
+			Name:         "site-a",
+			ControlPlane: true,
+			Template:     "/dc0/vm/templates/ubuntu-site-a",
+			Datacenter:   "dc0",
+			Folder:       "folder-a",
+			Datastore:    "vsan-stretched",
+			ResourcePool: "pool-a",
+			Network:      "vm-network-a",
+			HostGroup:    "site-a-hosts",
+			VMGroup:      "site-a-vms",
+		},
+		{
+			Name:      "site-b",
+			HostGroup: "site-b-hosts",
+			VMGroup:   "site-b-vms",
+		},
+		{
+			Name:         "site-d",
+			ControlPlane: true,
+			AntiAffinity: true,
+		},
+		{
+			Name:             "site-c",
+			Datastore:        "datastore-c",
+			DatastoreCluster: "sdrs-c",
+		},
+		{
+			Name: "zone-a",
+			TagSelector: &infrav1.FailureDomainTagSelector{
+				Category: "zone",
+				Tag:      "a",
+			},
+		},
+	}
+
+	for _, fd := range testCases {
+		t.Run(fd.Name, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+			spec := failuredomain.GetFailureDomain(fd)
+			g.Expect(failuredomain.SetFailureDomain(fd.Name, spec)).To(gomega.Equal(fd))
+		})
+	}
+}
+
+func TestReconcileFailureDomain(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	vsphereCluster := &infrav1.VSphereCluster{
+		Spec: infrav1.VSphereClusterSpec{
+			FailureDomains: []infrav1.VSphereFailureDomain{
+				{
+					Name:         "zone-a",
+					ControlPlane: true,
+					Datacenter:   "dc0",
+					Folder:       "folder-a",
+					Datastore:    "datastore-a",
+					ResourcePool: "pool-a",
+				},
+				{
+					Name:      "zone-b",
+					Datastore: "datastore-b",
+				},
+			},
+		},
+	}
+
+	computed, err := failuredomain.ComputeFailureDomains(vsphereCluster.Spec.FailureDomains)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(vsphereCluster.Status.FailureDomains).To(gomega.BeNil())
+
+	g.Expect(failuredomain.ReconcileFailureDomain(vsphereCluster)).To(gomega.Succeed())
+	g.Expect(vsphereCluster.Status.FailureDomains).To(gomega.Equal(computed))
+	g.Expect(vsphereCluster.Status.FailureDomains).To(gomega.Equal(clusterv1.FailureDomains{
+		"zone-a": clusterv1.FailureDomainSpec{
+			ControlPlane: true,
+			Attributes: map[string]string{
+				failuredomain.FailureDomainKeyDatacenter:   "dc0",
+				failuredomain.FailureDomainKeyFolder:       "folder-a",
+				failuredomain.FailureDomainKeyDatastore:    "datastore-a",
+				failuredomain.FailureDomainKeyResourcePool: "pool-a",
+			},
+		},
+		"zone-b": clusterv1.FailureDomainSpec{
+			Attributes: map[string]string{
+				failuredomain.FailureDomainKeyDatastore: "datastore-b",
+			},
+		},
+	}))
+
+	vsphereCluster.Spec.FailureDomains = nil
+	g.Expect(failuredomain.ReconcileFailureDomain(vsphereCluster)).To(gomega.Succeed())
+	g.Expect(vsphereCluster.Status.FailureDomains).To(gomega.BeNil())
+}
+
+func TestReconcileFailureDomainControlPlaneAndWorkers(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	vsphereCluster := &infrav1.VSphereCluster{
+		Spec: infrav1.VSphereClusterSpec{
+			FailureDomains: []infrav1.VSphereFailureDomain{
+				{Name: "cp-a", ControlPlane: true, Datastore: "datastore-a"},
+				{Name: "worker-a", Datastore: "datastore-a"},
+				{Name: "cp-b", ControlPlane: true, Datastore: "datastore-b"},
+				{Name: "worker-b", Datastore: "datastore-b"},
+			},
+		},
+	}
+
+	g.Expect(failuredomain.ReconcileFailureDomain(vsphereCluster)).To(gomega.Succeed())
+	g.Expect(vsphereCluster.Status.FailureDomains).To(gomega.HaveLen(4))
+	for name, controlPlane := range map[string]bool{
+		"cp-a":     true,
+		"worker-a": false,
+		"cp-b":     true,
+		"worker-b": false,
+	} {
+		g.Expect(vsphereCluster.Status.FailureDomains).To(gomega.HaveKey(name))
+		g.Expect(vsphereCluster.Status.FailureDomains[name].ControlPlane).To(gomega.Equal(controlPlane), name)
+	}
+	g.Expect(vsphereCluster.Status.FailureDomains.FilterControlPlane()).To(gomega.HaveLen(2))
+}
+
+func TestFailureDomainsToAnnotationRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name       string
+		annotation string
+	}{
+		{
+			name:       "empty",
+			annotation: "",
+		},
+		{
+			name: "all fields",
+			annotation: `[{"name":"site-a","controlPlane":true,"template":"/dc0/vm/templates/ubuntu-site-a",` +
+				`"datacenter":"dc0","folder":"folder-a","datastore":"vsan-stretched","resourcePool":"pool-a",` +
+				`"hostGroup":"site-a-hosts","vmGroup":"site-a-vms","antiAffinity":true},` +
+				`{"name":"site-c","datastore":"datastore-c","datastoreCluster":"sdrs-c"},` +
+				`{"name":"zone-a","tagSelector":{"category":"zone","tag":"a"}}]`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+
+			var fds []infrav1.VSphereFailureDomain
+			if tc.annotation != "" {
+				g.Expect(json.Unmarshal([]byte(tc.annotation), &fds)).To(gomega.Succeed())
+			}
+			status, err := failuredomain.ComputeFailureDomains(fds)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+
+			annotation, err := failuredomain.FailureDomainsToAnnotation(status)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(annotation).To(gomega.Equal(tc.annotation))
+		})
+	}
+}
+
+func TestComputeFailureDomainsInvalid(t *testing.T) {
+	testCases := []struct {
+		name          string
+		fds           []infrav1.VSphereFailureDomain
+		expectedError string
+	}{
+		{
+			name:          "missing name",
+			fds:           []infrav1.VSphereFailureDomain{{Datastore: "datastore-a"}},
+			expectedError: "failure domain 0 has no name",
+		},
+		{
+			name: "duplicate name",
+			fds: []infrav1.VSphereFailureDomain{
+				{Name: "zone-a", Datastore: "datastore-a"},
+				{Name: "zone-a", Datastore: "datastore-b"},
+			},
+			expectedError: `failure domain "zone-a" is defined more than once`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+			_, err := failuredomain.ComputeFailureDomains(tc.fds)
+			g.Expect(err).To(gomega.MatchError(tc.expectedError))
+
+			existing := clusterv1.FailureDomains{"zone-x": clusterv1.FailureDomainSpec{}}
+			vsphereCluster := &infrav1.VSphereCluster{
+				Spec:   infrav1.VSphereClusterSpec{FailureDomains: tc.fds},
+				Status: infrav1.VSphereClusterStatus{FailureDomains: existing},
+			}
+			g.Expect(failuredomain.ReconcileFailureDomain(vsphereCluster)).NotTo(gomega.Succeed())
+			g.Expect(vsphereCluster.Status.FailureDomains).To(gomega.Equal(existing))
+		})
+	}
+}
+
+func TestUpdateVSphereVMFromFailureDomain(t *testing.T) {
+	fds := clusterv1.FailureDomains{
+		"zone-a": clusterv1.FailureDomainSpec{
+			Attributes: map[string]string{
+				failuredomain.FailureDomainKeyTemplate:     "template-a",
+				failuredomain.FailureDomainKeyDatacenter:   "dc-a",
+				failuredomain.FailureDomainKeyFolder:       "folder-a",
+				failuredomain.FailureDomainKeyDatastore:    "datastore-a",
+				failuredomain.FailureDomainKeyResourcePool: "pool-a",
+			},
+		},
+		"zone-b": clusterv1.FailureDomainSpec{
+			Attributes: map[string]string{
+				failuredomain.FailureDomainKeyDatastore: "datastore-b",
+			},
+		},
+	}
+	original := infrav1.VirtualMachineCloneSpec{
+		Template:     "template0",
+		Datacenter:   "dc0",
+		Folder:       "folder0",
+		Datastore:    "datastore0",
+		ResourcePool: "pool0",
+	}
+
+	testCases := []struct {
+		name              string
+		fd                string
+		expected          infrav1.VirtualMachineCloneSpec
+		expectedApplied   bool
+		expectedOverrides map[string]string
+	}{
+		{
+			name: "all attributes are overridden",
+			fd:   "zone-a",
+			expected: infrav1.VirtualMachineCloneSpec{
+				Template:     "template-a",
+				Datacenter:   "dc-a",
+				Folder:       "folder-a",
+				Datastore:    "datastore-a",
+				ResourcePool: "pool-a",
+			},
+			expectedApplied:   true,
+			expectedOverrides: fds["zone-a"].Attributes,
+		},
+		{
+			name: "only set attributes are overridden",
+			fd:   "zone-b",
+			expected: infrav1.VirtualMachineCloneSpec{
+				Template:     "template0",
+				Datacenter:   "dc0",
+				Folder:       "folder0",
+				Datastore:    "datastore-b",
+				ResourcePool: "pool0",
+			},
+			expectedApplied: true,
+			expectedOverrides: map[string]string{
+				failuredomain.FailureDomainKeyDatastore: "datastore-b",
+			},
+		},
+		{
+			name:            "unknown failure domain",
+			fd:              "zone-x",
+			expected:        original,
+			expectedApplied: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+			vm := &infrav1.VSphereVM{
+				Spec: infrav1.VSphereVMSpec{VirtualMachineCloneSpec: original},
+			}
+			applied, overrides, err := failuredomain.UpdateVSphereVMFromFailureDomain(logrtesting.NullLogger{}, vm, fds, tc.fd)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(applied).To(gomega.Equal(tc.expectedApplied))
+			if tc.expectedOverrides == nil {
+				g.Expect(overrides).To(gomega.BeEmpty())
+			} else {
+				g.Expect(overrides).To(gomega.Equal(tc.expectedOverrides))
+			}
+			g.Expect(vm.Spec.VirtualMachineCloneSpec).To(gomega.Equal(tc.expected))
+		})
+	}
+}
+
+func TestUpdateVSphereVMFromFailureDomainInvalid(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	fds := clusterv1.FailureDomains{
+		"zone-a": clusterv1.FailureDomainSpec{
+			Attributes: map[string]string{
+				failuredomain.FailureDomainKeyDatastore:   "datastore-a",
+				failuredomain.FailureDomainKeyTagCategory: "k8s-zone",
+			},
+		},
+	}
+	vm := &infrav1.VSphereVM{
+		Spec: infrav1.VSphereVMSpec{
+			VirtualMachineCloneSpec: infrav1.VirtualMachineCloneSpec{Datastore: "datastore0"},
+		},
+	}
+	applied, overrides, err := failuredomain.UpdateVSphereVMFromFailureDomain(logrtesting.NullLogger{}, vm, fds, "zone-a")
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(applied).To(gomega.BeTrue())
+	g.Expect(overrides).To(gomega.BeEmpty())
+	g.Expect(vm.Spec.Datastore).To(gomega.Equal("datastore0"))
+	g.Expect(vm.Annotations).To(gomega.BeEmpty())
+}
+
+// logEntry is a message logged by a recordingLogger with its key/value
+// pairs, including those added by WithValues.
+type logEntry struct {
+	msg    string
+	values map[string]interface{}
+}
+
+// recordingLogger is a logr.Logger that records the messages logged through
+// it and any logger derived from it.
+type recordingLogger struct {
+	entries *[]logEntry
+	values  []interface{}
+}
+
+func (l recordingLogger) Enabled() bool { return true }
+
+func (l recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	values := map[string]interface{}{}
+	kvs := append(append([]interface{}{}, l.values...), keysAndValues...)
+	for i := 0; i+1 < len(kvs); i += 2 {
+		values[kvs[i].(string)] = kvs[i+1]
+	}
+	*l.entries = append(*l.entries, logEntry{msg: msg, values: values})
+}
+
+func (l recordingLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.Info(msg, append(keysAndValues, "error", err)...)
+}
+
+func (l recordingLogger) V(_ int) logr.InfoLogger { return l }
+
+func (l recordingLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	l.values = append(append([]interface{}{}, l.values...), keysAndValues...)
+	return l
+}
+
+func (l recordingLogger) WithName(_ string) logr.Logger { return l }
+
+func TestUpdateVSphereVMFromFailureDomainLogsPrecedence(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	fds := clusterv1.FailureDomains{
+		"zone-a": failuredomain.GetFailureDomain(infrav1.VSphereFailureDomain{
+			Name:      "zone-a",
+			Datastore: "datastore-a",
+		}),
+	}
+	vm := &infrav1.VSphereVM{
+		Spec: infrav1.VSphereVMSpec{
+			VirtualMachineCloneSpec: infrav1.VirtualMachineCloneSpec{
+				Datacenter: "dc0",
+				Datastore:  "datastore0",
+			},
+		},
+	}
+
+	logger := recordingLogger{entries: &[]logEntry{}}
+	_, _, err := failuredomain.UpdateVSphereVMFromFailureDomain(logger, vm, fds, "zone-a")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	fields := map[string]map[string]interface{}{}
+	for _, entry := range *logger.entries {
+		g.Expect(entry.msg).To(gomega.Equal("resolved VSphereVM placement field"))
+		fields[entry.values["field"].(string)] = entry.values
+	}
+	g.Expect(fields).To(gomega.HaveLen(2))
+	g.Expect(fields).To(gomega.HaveKeyWithValue(failuredomain.FailureDomainKeyDatacenter, map[string]interface{}{
+		"failure-domain": "zone-a",
+		"field":          failuredomain.FailureDomainKeyDatacenter,
+		"spec":           "dc0",
+		"failureDomain":  "",
+		"source":         "spec",
+	}))
+	g.Expect(fields).To(gomega.HaveKeyWithValue(failuredomain.FailureDomainKeyDatastore, map[string]interface{}{
+		"failure-domain": "zone-a",
+		"field":          failuredomain.FailureDomainKeyDatastore,
+		"spec":           "datastore0",
+		"failureDomain":  "datastore-a",
+		"source":         "failureDomain",
+	}))
+}
+
+func TestPickFailureDomain(t *testing.T) {
+	fds := clusterv1.FailureDomains{
+		"zone-c": clusterv1.FailureDomainSpec{ControlPlane: true},
+		"zone-a": clusterv1.FailureDomainSpec{ControlPlane: true},
+		"zone-b": clusterv1.FailureDomainSpec{ControlPlane: true},
+	}
+
+	testCases := []struct {
+		name     string
+		fds      clusterv1.FailureDomains
+		existing []string
+		expected string
+	}{
+		{
+			name:     "no failure domains",
+			expected: "",
+		},
+		{
+			name:     "no existing machines picks the first sorted name",
+			fds:      fds,
+			expected: "zone-a",
+		},
+		{
+			name:     "least used failure domain",
+			fds:      fds,
+			existing: []string{"zone-a", "zone-b", "zone-a", "zone-c", "zone-b"},
+			expected: "zone-c",
+		},
+		{
+			name:     "unknown failure domains are ignored",
+			fds:      fds,
+			existing: []string{"zone-x", "zone-x", "zone-a"},
+			expected: "zone-b",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+			g.Expect(failuredomain.PickFailureDomain(tc.fds, tc.existing)).To(gomega.Equal(tc.expected))
+		})
+	}
+}
+
+func TestPickFailureDomainSpreadsSequentialPicks(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	fds := clusterv1.FailureDomains{
+		"zone-a": clusterv1.FailureDomainSpec{ControlPlane: true},
+		"zone-b": clusterv1.FailureDomainSpec{ControlPlane: true},
+		"zone-c": clusterv1.FailureDomainSpec{ControlPlane: true},
+	}
+
+	var existing []string
+	for i := 0; i < 5; i++ {
+		existing = append(existing, failuredomain.PickFailureDomain(fds, existing))
+	}
+	g.Expect(existing).To(gomega.Equal([]string{"zone-a", "zone-b", "zone-c", "zone-a", "zone-b"}))
+}
+
+func TestUpdateVSphereVMFromFailureDomainGroups(t *testing.T) {
+	fds := clusterv1.FailureDomains{
+		"site-a": failuredomain.GetFailureDomain(infrav1.VSphereFailureDomain{
+			Name:      "site-a",
+			HostGroup: "site-a-hosts",
+			VMGroup:   "site-a-vms",
+		}),
+		"site-b": failuredomain.GetFailureDomain(infrav1.VSphereFailureDomain{
+			Name:      "site-b",
+			Datastore: "datastore-b",
+		}),
+	}
+
+	t.Run("groups are recorded as annotations", func(t *testing.T) {
+		g := gomega.NewWithT(t)
+		vm := &infrav1.VSphereVM{}
+		failuredomain.UpdateVSphereVMFromFailureDomain(logrtesting.NullLogger{}, vm, fds, "site-a")
+		g.Expect(vm.Annotations).To(gomega.Equal(map[string]string{
+			constants.HostGroupAnnotationLabel: "site-a-hosts",
+			constants.VMGroupAnnotationLabel:   "site-a-vms",
+		}))
+	})
+
+	t.Run("no annotations without groups", func(t *testing.T) {
+		g := gomega.NewWithT(t)
+		vm := &infrav1.VSphereVM{}
+		failuredomain.UpdateVSphereVMFromFailureDomain(logrtesting.NullLogger{}, vm, fds, "site-b")
+		g.Expect(vm.Annotations).To(gomega.BeEmpty())
+	})
+}
+
+func TestUpdateVSphereVMFromFailureDomainNetwork(t *testing.T) {
+	fds := clusterv1.FailureDomains{
+		"zone-a": failuredomain.GetFailureDomain(infrav1.VSphereFailureDomain{
+			Name:    "zone-a",
+			Network: "vm-network-a",
+		}),
+		"zone-b": failuredomain.GetFailureDomain(infrav1.VSphereFailureDomain{
+			Name:      "zone-b",
+			Datastore: "datastore-b",
+		}),
+	}
+
+	testCases := []struct {
+		name              string
+		fd                string
+		expectedNetworks  []string
+		expectedOverrides map[string]string
+	}{
+		{
+			name:             "first device network is overridden",
+			fd:               "zone-a",
+			expectedNetworks: []string{"vm-network-a", "storage-network"},
+			expectedOverrides: map[string]string{
+				failuredomain.FailureDomainKeyNetwork: "vm-network-a",
+			},
+		},
+		{
+			name:             "network is kept without a failure domain network",
+			fd:               "zone-b",
+			expectedNetworks: []string{"vm-network", "storage-network"},
+			expectedOverrides: map[string]string{
+				failuredomain.FailureDomainKeyDatastore: "datastore-b",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+			vm := &infrav1.VSphereVM{
+				Spec: infrav1.VSphereVMSpec{
+					VirtualMachineCloneSpec: infrav1.VirtualMachineCloneSpec{
+						Network: infrav1.NetworkSpec{
+							Devices: []infrav1.NetworkDeviceSpec{
+								{NetworkName: "vm-network"},
+								{NetworkName: "storage-network"},
+							},
+						},
+					},
+				},
+			}
+			_, overrides, err := failuredomain.UpdateVSphereVMFromFailureDomain(logrtesting.NullLogger{}, vm, fds, tc.fd)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(overrides).To(gomega.Equal(tc.expectedOverrides))
+			networks := []string{}
+			for _, device := range vm.Spec.Network.Devices {
+				networks = append(networks, device.NetworkName)
+			}
+			g.Expect(networks).To(gomega.Equal(tc.expectedNetworks))
+		})
+	}
+
+	t.Run("VSphereVM without network devices", func(t *testing.T) {
+		g := gomega.NewWithT(t)
+		vm := &infrav1.VSphereVM{}
+		_, overrides, err := failuredomain.UpdateVSphereVMFromFailureDomain(logrtesting.NullLogger{}, vm, fds, "zone-a")
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(overrides).To(gomega.BeEmpty())
+		g.Expect(vm.Spec.Network.Devices).To(gomega.BeEmpty())
+	})
+}
+
+func TestUpdateVSphereVMFromFailureDomainAntiAffinity(t *testing.T) {
+	fds := clusterv1.FailureDomains{
+		"anti-affinity": failuredomain.GetFailureDomain(infrav1.VSphereFailureDomain{
+			Name:         "anti-affinity",
+			ControlPlane: true,
+			AntiAffinity: true,
+		}),
+		"no-anti-affinity": failuredomain.GetFailureDomain(infrav1.VSphereFailureDomain{
+			Name:         "no-anti-affinity",
+			ControlPlane: true,
+		}),
+	}
+	controlPlaneLabels := map[string]string{
+		clusterv1.ClusterLabelName:             "cluster-a",
+		clusterv1.MachineControlPlaneLabelName: "",
+	}
+	workerLabels := map[string]string{
+		clusterv1.ClusterLabelName: "cluster-a",
+	}
+
+	testCases := []struct {
+		name          string
+		fd            string
+		labels        map[string]string
+		expectedGroup string
+	}{
+		{
+			name:          "control plane VM with anti-affinity",
+			fd:            "anti-affinity",
+			labels:        controlPlaneLabels,
+			expectedGroup: "default-cluster-a-control-plane",
+		},
+		{
+			name:   "control plane VM without anti-affinity",
+			fd:     "no-anti-affinity",
+			labels: controlPlaneLabels,
+		},
+		{
+			name:   "worker VM with anti-affinity",
+			fd:     "anti-affinity",
+			labels: workerLabels,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+			vm := &infrav1.VSphereVM{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "default",
+					Labels:    tc.labels,
+				},
+			}
+			_, overrides, err := failuredomain.UpdateVSphereVMFromFailureDomain(logrtesting.NullLogger{}, vm, fds, tc.fd)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			if tc.expectedGroup == "" {
+				g.Expect(vm.Annotations).NotTo(gomega.HaveKey(constants.AntiAffinityGroupAnnotationLabel))
+				g.Expect(overrides).NotTo(gomega.HaveKey(failuredomain.FailureDomainKeyAntiAffinity))
+			} else {
+				g.Expect(vm.Annotations).To(gomega.HaveKeyWithValue(constants.AntiAffinityGroupAnnotationLabel, tc.expectedGroup))
+				g.Expect(overrides).To(gomega.HaveKeyWithValue(failuredomain.FailureDomainKeyAntiAffinity, tc.expectedGroup))
+			}
+		})
+	}
+}
+
+func TestUpdateVSphereVMFromFailureDomainDatastoreCluster(t *testing.T) {
+	fds := clusterv1.FailureDomains{
+		"datastore": failuredomain.GetFailureDomain(infrav1.VSphereFailureDomain{
+			Name:      "datastore",
+			Datastore: "datastore-a",
+		}),
+		"datastore-cluster": failuredomain.GetFailureDomain(infrav1.VSphereFailureDomain{
+			Name:             "datastore-cluster",
+			DatastoreCluster: "sdrs-a",
+		}),
+		"both": failuredomain.GetFailureDomain(infrav1.VSphereFailureDomain{
+			Name:             "both",
+			Datastore:        "datastore-a",
+			DatastoreCluster: "sdrs-a",
+		}),
+	}
+
+	testCases := []struct {
+		name                string
+		fd                  string
+		expectedDatastore   string
+		expectedAnnotations map[string]string
+		expectedOverrides   map[string]string
+	}{
+		{
+			name:              "datastore removes the datastore cluster",
+			fd:                "datastore",
+			expectedDatastore: "datastore-a",
+			expectedOverrides: map[string]string{
+				failuredomain.FailureDomainKeyDatastore: "datastore-a",
+			},
+		},
+		{
+			name:              "datastore cluster clears the datastore",
+			fd:                "datastore-cluster",
+			expectedDatastore: "",
+			expectedAnnotations: map[string]string{
+				constants.DatastoreClusterAnnotationLabel: "sdrs-a",
+			},
+			expectedOverrides: map[string]string{
+				failuredomain.FailureDomainKeyDatastore:        "",
+				failuredomain.FailureDomainKeyDatastoreCluster: "sdrs-a",
+			},
+		},
+		{
+			name:              "datastore cluster takes precedence over datastore",
+			fd:                "both",
+			expectedDatastore: "",
+			expectedAnnotations: map[string]string{
+				constants.DatastoreClusterAnnotationLabel: "sdrs-a",
+			},
+			expectedOverrides: map[string]string{
+				failuredomain.FailureDomainKeyDatastore:        "",
+				failuredomain.FailureDomainKeyDatastoreCluster: "sdrs-a",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+			// The VSphereVM carries the datastore cluster of a failure
+			// domain it was previously placed in.
+			vm := &infrav1.VSphereVM{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						constants.DatastoreClusterAnnotationLabel: "sdrs-stale",
+					},
+				},
+				Spec: infrav1.VSphereVMSpec{
+					VirtualMachineCloneSpec: infrav1.VirtualMachineCloneSpec{
+						Datastore: "datastore0",
+					},
+				},
+			}
+			_, overrides, err := failuredomain.UpdateVSphereVMFromFailureDomain(logrtesting.NullLogger{}, vm, fds, tc.fd)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(overrides).To(gomega.Equal(tc.expectedOverrides))
+			g.Expect(vm.Spec.Datastore).To(gomega.Equal(tc.expectedDatastore))
+			if tc.expectedAnnotations == nil {
+				g.Expect(vm.Annotations).To(gomega.BeEmpty())
+			} else {
+				g.Expect(vm.Annotations).To(gomega.Equal(tc.expectedAnnotations))
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package failuredomain
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/session"
+)
+
+// ValidateResourcePool returns an error if the VSphereVM's resource pool is
+// a bare name used by resource pools in more than one of the compute
+// clusters named by fds, since the pool in which the VM would be created is
+// then ambiguous. A failure domain names a compute cluster with the
+// inventory path of its resource pool. Pools in other compute clusters of
+// the VSphereVM's datacenter are not counted. A resource pool given as an
+// inventory path is not checked; it is resolved when the VM is cloned.
+func ValidateResourcePool(ctx context.Context, s *session.Session, vm *infrav1.VSphereVM, fds clusterv1.FailureDomains) error {
+	name := vm.Spec.ResourcePool
+	if name == "" || strings.Contains(name, "/") {
+		return nil
+	}
+
+	finder, err := s.FinderForDatacenter(ctx, vm.Spec.Datacenter)
+	if err != nil {
+		return err
+	}
+
+	// The compute clusters are the owners of the failure domains' resource
+	// pools. A pool that no longer exists names no compute cluster.
+	clusters := map[types.ManagedObjectReference]struct{}{}
+	for _, fd := range fds {
+		path := fd.Attributes[FailureDomainKeyResourcePool]
+		if !strings.Contains(path, "/") {
+			continue
+		}
+		pool, err := finder.ResourcePool(ctx, path)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return errors.Wrapf(err, "unable to find resource pool %q", path)
+		}
+		var mpool mo.ResourcePool
+		if err := pool.Properties(ctx, pool.Reference(), []string{"owner"}, &mpool); err != nil {
+			return errors.Wrapf(err, "unable to get owner of resource pool %q", path)
+		}
+		clusters[mpool.Owner] = struct{}{}
+	}
+	if len(clusters) < 2 {
+		return nil
+	}
+
+	dc, err := finder.DatacenterOrDefault(ctx, "")
+	if err != nil {
+		return errors.Wrapf(err, "unable to find datacenter %q", vm.Spec.Datacenter)
+	}
+	folders, err := dc.Folders(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get folders of datacenter %q", dc.InventoryPath)
+	}
+
+	// A single view of the host folder lists the resource pools of every
+	// compute resource in the datacenter, of which only those in the
+	// failure domains' compute clusters are counted.
+	v, err := view.NewManager(s.Client.Client).CreateContainerView(ctx, folders.HostFolder.Reference(), []string{"ResourcePool"}, true)
+	if err != nil {
+		return errors.Wrapf(err, "unable to list resource pools in %q", dc.InventoryPath)
+	}
+	var pools []mo.ResourcePool
+	err = v.Retrieve(ctx, []string{"ResourcePool"}, []string{"name", "owner"}, &pools)
+	_ = v.Destroy(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "unable to list resource pools in %q", dc.InventoryPath)
+	}
+
+	owners := map[types.ManagedObjectReference]struct{}{}
+	for _, pool := range pools {
+		if _, ok := clusters[pool.Owner]; ok && pool.Name == name {
+			owners[pool.Owner] = struct{}{}
+		}
+	}
+	if len(owners) < 2 {
+		return nil
+	}
+
+	matches := make([]string, 0, len(owners))
+	for owner := range owners {
+		e, err := finder.Element(ctx, owner)
+		if err != nil {
+			return errors.Wrapf(err, "unable to find compute resource %s", owner)
+		}
+		matches = append(matches, e.Path)
+	}
+	sort.Strings(matches)
+	return errors.Errorf(
+		"resource pool %q exists in compute clusters %s, use its inventory path instead",
+		name, strings.Join(matches, ", "))
+}
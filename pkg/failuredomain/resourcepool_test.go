@@ -0,0 +1,143 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package failuredomain_test
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/failuredomain"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/session"
+)
+
+func TestValidateResourcePool(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	model := simulator.VPX()
+	model.Host = 0
+	model.Cluster = 3
+	g.Expect(model.Create()).To(gomega.Succeed())
+	defer model.Remove()
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	s, err := session.GetOrCreate(ctx, session.NewParams().
+		WithServer(server.URL.Host).
+		WithInsecure(true).
+		WithDatacenter("DC0").
+		WithUserInfo(server.URL.User.Username(), pass))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// Every cluster has a pool named k8s. Only the first has a pool named
+	// unique, nested under its k8s pool.
+	for _, cluster := range []string{"DC0_C0", "DC0_C1", "DC0_C2"} {
+		root, err := s.Finder.ResourcePool(ctx, "/DC0/host/"+cluster+"/Resources")
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		pool, err := root.Create(ctx, "k8s", types.DefaultResourceConfigSpec())
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		if cluster == "DC0_C0" {
+			_, err = pool.Create(ctx, "unique", types.DefaultResourceConfigSpec())
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+		}
+	}
+
+	// The failure domains name the first two clusters by the inventory
+	// paths of their pools. A bare name names no cluster.
+	fds := clusterv1.FailureDomains{
+		"zone-a": {Attributes: map[string]string{failuredomain.FailureDomainKeyResourcePool: "/DC0/host/DC0_C0/Resources/k8s"}},
+		"zone-b": {Attributes: map[string]string{failuredomain.FailureDomainKeyResourcePool: "/DC0/host/DC0_C1/Resources/k8s"}},
+		"zone-c": {Attributes: map[string]string{failuredomain.FailureDomainKeyResourcePool: "k8s"}},
+	}
+
+	testCases := []struct {
+		name           string
+		resourcePool   string
+		failureDomains clusterv1.FailureDomains
+		expectErr      bool
+	}{
+		{
+			name:           "no resource pool",
+			failureDomains: fds,
+		},
+		{
+			name:           "name used in several clusters of the failure domains",
+			resourcePool:   "k8s",
+			failureDomains: fds,
+			expectErr:      true,
+		},
+		{
+			name:         "name used in one cluster of the failure domains and in others outside them",
+			resourcePool: "k8s",
+			failureDomains: clusterv1.FailureDomains{
+				"zone-a": fds["zone-a"],
+				"zone-c": fds["zone-c"],
+			},
+		},
+		{
+			name:         "failure domains that name no cluster",
+			resourcePool: "k8s",
+			failureDomains: clusterv1.FailureDomains{
+				"zone-c": fds["zone-c"],
+			},
+		},
+		{
+			name:           "inventory path of a name used in several clusters",
+			resourcePool:   "/DC0/host/DC0_C1/Resources/k8s",
+			failureDomains: fds,
+		},
+		{
+			name:           "nested name used in one cluster",
+			resourcePool:   "unique",
+			failureDomains: fds,
+		},
+		{
+			name:           "name used in no cluster",
+			resourcePool:   "missing",
+			failureDomains: fds,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+			vm := &infrav1.VSphereVM{
+				Spec: infrav1.VSphereVMSpec{
+					VirtualMachineCloneSpec: infrav1.VirtualMachineCloneSpec{
+						Datacenter:   "DC0",
+						ResourcePool: tc.resourcePool,
+					},
+				},
+			}
+			err := failuredomain.ValidateResourcePool(ctx, s, vm, tc.failureDomains)
+			if tc.expectErr {
+				g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("/DC0/host/DC0_C0, /DC0/host/DC0_C1, use its inventory path")))
+			} else {
+				g.Expect(err).NotTo(gomega.HaveOccurred())
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package failuredomain
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/vim25/types"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/constants"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/session"
+)
+
+// tagAnnotations are the annotations that record the tag that selects a
+// VSphereVM's placement and the placement resolved from it.
+var tagAnnotations = []string{
+	constants.TagCategoryAnnotationLabel,
+	constants.TagAnnotationLabel,
+	constants.TagDatastoreAnnotationLabel,
+	constants.TagResourcePoolAnnotationLabel,
+}
+
+// ResolveTagSelector sets the datastore and resource pool of the VSphereVM
+// from the compute cluster and datastore, in the VSphereVM's datacenter,
+// that carry the tag recorded in its annotations by
+// UpdateVSphereVMFromFailureDomain. If several objects of a kind carry the
+// tag, the one whose inventory path sorts first is used. The resolved
+// placement is recorded in the VSphereVM's annotations, and is applied
+// without contacting vSphere once recorded. The VSphereVM is unchanged if
+// it has no tag annotations.
+func ResolveTagSelector(ctx context.Context, s *session.Session, vm *infrav1.VSphereVM) error {
+	category := vm.Annotations[constants.TagCategoryAnnotationLabel]
+	tag := vm.Annotations[constants.TagAnnotationLabel]
+	if tag == "" {
+		return nil
+	}
+	if applyTagPlacement(vm) {
+		return nil
+	}
+
+	tagManager, err := s.TagManager(ctx)
+	if err != nil {
+		return err
+	}
+	t, err := tagManager.GetTagForCategory(ctx, tag, category)
+	if err != nil {
+		return errors.Wrapf(err, "unable to find tag %q in category %q", tag, category)
+	}
+	attached, err := tagManager.ListAttachedObjects(ctx, t.ID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to list objects with tag %q in category %q", tag, category)
+	}
+	tagged := make(map[types.ManagedObjectReference]bool, len(attached))
+	for _, ref := range attached {
+		tagged[ref.Reference()] = true
+	}
+
+	finder, err := s.FinderForDatacenter(ctx, vm.Spec.Datacenter)
+	if err != nil {
+		return err
+	}
+
+	datastores, err := finder.DatastoreList(ctx, "*")
+	if err != nil && !isNotFound(err) {
+		return errors.Wrap(err, "unable to list datastores")
+	}
+	var datastorePaths []string
+	for _, ds := range datastores {
+		if tagged[ds.Reference()] {
+			datastorePaths = append(datastorePaths, ds.InventoryPath)
+		}
+	}
+
+	clusters, err := finder.ClusterComputeResourceList(ctx, "*")
+	if err != nil && !isNotFound(err) {
+		return errors.Wrap(err, "unable to list compute clusters")
+	}
+	var resourcePoolPaths []string
+	for _, cluster := range clusters {
+		if tagged[cluster.Reference()] {
+			resourcePoolPaths = append(resourcePoolPaths, cluster.InventoryPath+"/Resources")
+		}
+	}
+
+	if len(datastorePaths) == 0 && len(resourcePoolPaths) == 0 {
+		return errors.Errorf("no compute cluster or datastore has tag %q in category %q", tag, category)
+	}
+	if len(datastorePaths) > 0 {
+		sort.Strings(datastorePaths)
+		vm.Annotations[constants.TagDatastoreAnnotationLabel] = datastorePaths[0]
+	}
+	if len(resourcePoolPaths) > 0 {
+		sort.Strings(resourcePoolPaths)
+		vm.Annotations[constants.TagResourcePoolAnnotationLabel] = resourcePoolPaths[0]
+	}
+	applyTagPlacement(vm)
+	return nil
+}
+
+// applyTagPlacement sets the datastore and resource pool of the VSphereVM to
+// those recorded by ResolveTagSelector. It returns false if none have been
+// recorded.
+func applyTagPlacement(vm *infrav1.VSphereVM) bool {
+	datastore := vm.Annotations[constants.TagDatastoreAnnotationLabel]
+	resourcePool := vm.Annotations[constants.TagResourcePoolAnnotationLabel]
+	if datastore != "" {
+		vm.Spec.Datastore = datastore
+	}
+	if resourcePool != "" {
+		vm.Spec.ResourcePool = resourcePool
+	}
+	return datastore != "" || resourcePool != ""
+}
+
+func isNotFound(err error) bool {
+	_, ok := err.(*find.NotFoundError)
+	return ok
+}
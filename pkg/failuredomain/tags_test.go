@@ -0,0 +1,175 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package failuredomain_test
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+
+	logrtesting "github.com/go-logr/logr/testing"
+	"github.com/onsi/gomega"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vapi/tags"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+
+	_ "github.com/vmware/govmomi/vapi/simulator"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/constants"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/failuredomain"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/session"
+)
+
+func TestResolveTagSelector(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	model := simulator.VPX()
+	model.Host = 0
+	g.Expect(model.Create()).To(gomega.Succeed())
+	defer model.Remove()
+	model.Service.TLS = new(tls.Config)
+	model.Service.RegisterEndpoints = true
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	s, err := session.GetOrCreate(ctx, session.NewParams().
+		WithServer(server.URL.Host).
+		WithInsecure(true).
+		WithDatacenter("DC0").
+		WithUserInfo(server.URL.User.Username(), pass))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	tagManager, err := s.TagManager(ctx)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	categoryID, err := tagManager.CreateCategory(ctx, &tags.Category{Name: "zone", Cardinality: "SINGLE"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	zoneA, err := tagManager.CreateTag(ctx, &tags.Tag{Name: "a", CategoryID: categoryID})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	_, err = tagManager.CreateTag(ctx, &tags.Tag{Name: "b", CategoryID: categoryID})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	datastore, err := s.Finder.Datastore(ctx, "LocalDS_0")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	cluster, err := s.Finder.ClusterComputeResource(ctx, "DC0_C0")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(tagManager.AttachTag(ctx, zoneA, datastore.Reference())).To(gomega.Succeed())
+	g.Expect(tagManager.AttachTag(ctx, zoneA, cluster.Reference())).To(gomega.Succeed())
+
+	fds := clusterv1.FailureDomains{
+		"zone-a": failuredomain.GetFailureDomain(infrav1.VSphereFailureDomain{
+			Name:        "zone-a",
+			TagSelector: &infrav1.FailureDomainTagSelector{Category: "zone", Tag: "a"},
+		}),
+		"zone-b": failuredomain.GetFailureDomain(infrav1.VSphereFailureDomain{
+			Name:        "zone-b",
+			TagSelector: &infrav1.FailureDomainTagSelector{Category: "zone", Tag: "b"},
+		}),
+		"no-tag": failuredomain.GetFailureDomain(infrav1.VSphereFailureDomain{
+			Name:       "no-tag",
+			Datacenter: "DC0",
+		}),
+	}
+
+	t.Run("tag attached to a datastore and cluster", func(t *testing.T) {
+		g := gomega.NewWithT(t)
+		vm := &infrav1.VSphereVM{
+			Spec: infrav1.VSphereVMSpec{
+				VirtualMachineCloneSpec: infrav1.VirtualMachineCloneSpec{
+					Datacenter:   "DC0",
+					Datastore:    "ds-default",
+					ResourcePool: "pool-default",
+				},
+			},
+		}
+		failuredomain.UpdateVSphereVMFromFailureDomain(logrtesting.NullLogger{}, vm, fds, "zone-a")
+		g.Expect(vm.Annotations).To(gomega.HaveKeyWithValue(constants.TagCategoryAnnotationLabel, "zone"))
+		g.Expect(vm.Annotations).To(gomega.HaveKeyWithValue(constants.TagAnnotationLabel, "a"))
+
+		g.Expect(failuredomain.ResolveTagSelector(ctx, s, vm)).To(gomega.Succeed())
+		g.Expect(vm.Spec.Datastore).To(gomega.Equal("/DC0/datastore/LocalDS_0"))
+		g.Expect(vm.Spec.ResourcePool).To(gomega.Equal("/DC0/host/DC0_C0/Resources"))
+		g.Expect(vm.Annotations).To(gomega.HaveKeyWithValue(constants.TagDatastoreAnnotationLabel, "/DC0/datastore/LocalDS_0"))
+		g.Expect(vm.Annotations).To(gomega.HaveKeyWithValue(constants.TagResourcePoolAnnotationLabel, "/DC0/host/DC0_C0/Resources"))
+
+		// Placing the VSphereVM again, as the machine controller does on
+		// every reconcile, keeps the resolved placement.
+		vm.Spec.Datastore, vm.Spec.ResourcePool = "ds-default", "pool-default"
+		failuredomain.UpdateVSphereVMFromFailureDomain(logrtesting.NullLogger{}, vm, fds, "zone-a")
+		g.Expect(vm.Spec.Datastore).To(gomega.Equal("/DC0/datastore/LocalDS_0"))
+		g.Expect(vm.Spec.ResourcePool).To(gomega.Equal("/DC0/host/DC0_C0/Resources"))
+
+		// The recorded placement is applied without a session.
+		vm.Spec.Datastore, vm.Spec.ResourcePool = "ds-default", "pool-default"
+		g.Expect(failuredomain.ResolveTagSelector(ctx, nil, vm)).To(gomega.Succeed())
+		g.Expect(vm.Spec.Datastore).To(gomega.Equal("/DC0/datastore/LocalDS_0"))
+		g.Expect(vm.Spec.ResourcePool).To(gomega.Equal("/DC0/host/DC0_C0/Resources"))
+	})
+
+	t.Run("failure domain changes tag", func(t *testing.T) {
+		g := gomega.NewWithT(t)
+		vm := &infrav1.VSphereVM{
+			Spec: infrav1.VSphereVMSpec{
+				VirtualMachineCloneSpec: infrav1.VirtualMachineCloneSpec{
+					Datacenter: "DC0",
+				},
+			},
+		}
+		failuredomain.UpdateVSphereVMFromFailureDomain(logrtesting.NullLogger{}, vm, fds, "zone-a")
+		g.Expect(failuredomain.ResolveTagSelector(ctx, s, vm)).To(gomega.Succeed())
+
+		failuredomain.UpdateVSphereVMFromFailureDomain(logrtesting.NullLogger{}, vm, fds, "zone-b")
+		g.Expect(vm.Annotations).To(gomega.HaveKeyWithValue(constants.TagAnnotationLabel, "b"))
+		g.Expect(vm.Annotations).NotTo(gomega.HaveKey(constants.TagDatastoreAnnotationLabel))
+		g.Expect(vm.Annotations).NotTo(gomega.HaveKey(constants.TagResourcePoolAnnotationLabel))
+
+		failuredomain.UpdateVSphereVMFromFailureDomain(logrtesting.NullLogger{}, vm, fds, "no-tag")
+		g.Expect(vm.Annotations).To(gomega.BeEmpty())
+	})
+
+	t.Run("tag attached to nothing", func(t *testing.T) {
+		g := gomega.NewWithT(t)
+		vm := &infrav1.VSphereVM{
+			Spec: infrav1.VSphereVMSpec{
+				VirtualMachineCloneSpec: infrav1.VirtualMachineCloneSpec{
+					Datacenter: "DC0",
+				},
+			},
+		}
+		failuredomain.UpdateVSphereVMFromFailureDomain(logrtesting.NullLogger{}, vm, fds, "zone-b")
+		g.Expect(failuredomain.ResolveTagSelector(ctx, s, vm)).NotTo(gomega.Succeed())
+	})
+
+	t.Run("no tag annotations", func(t *testing.T) {
+		g := gomega.NewWithT(t)
+		vm := &infrav1.VSphereVM{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{"unrelated": "value"},
+			},
+			Spec: infrav1.VSphereVMSpec{
+				VirtualMachineCloneSpec: infrav1.VirtualMachineCloneSpec{
+					Datastore: "ds-default",
+				},
+			},
+		}
+		g.Expect(failuredomain.ResolveTagSelector(ctx, s, vm)).To(gomega.Succeed())
+		g.Expect(vm.Spec.Datastore).To(gomega.Equal("ds-default"))
+	})
+}
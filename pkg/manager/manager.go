@@ -19,6 +19,7 @@ package manager
 import (
 	goctx "context"
 	"fmt"
+	"io/ioutil"
 	"os"
 
 	"github.com/pkg/errors"
@@ -63,6 +64,13 @@ func New(opts Options) (Manager, error) {
 		podName = DefaultPodName
 	}
 
+	var caCerts []byte
+	if opts.CACertsFile != "" {
+		if caCerts, err = ioutil.ReadFile(opts.CACertsFile); err != nil {
+			return nil, errors.Wrapf(err, "unable to read CA certificates from %q", opts.CACertsFile)
+		}
+	}
+
 	// Build the controller manager.
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                  opts.Scheme,
@@ -95,6 +103,9 @@ func New(opts Options) (Manager, error) {
 		Scheme:                  opts.Scheme,
 		Username:                opts.Username,
 		Password:                opts.Password,
+		Insecure:                opts.Insecure,
+		Thumbprint:              opts.Thumbprint,
+		CACerts:                 caCerts,
 	}
 
 	// Add the requested items to the manager.
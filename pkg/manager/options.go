@@ -98,6 +98,18 @@ type Options struct {
 	// endpoints.
 	Password string
 
+	// Insecure skips verifying the certificates of remote vSphere endpoints
+	// when neither Thumbprint nor CACertsFile is set.
+	Insecure bool
+
+	// Thumbprint is the SHA-1 thumbprint used to verify the certificates of
+	// remote vSphere endpoints.
+	Thumbprint string
+
+	// CACertsFile is the file that contains the PEM-encoded CA certificates
+	// used to verify the certificates of remote vSphere endpoints.
+	CACertsFile string
+
 	// WebhookPort is the port that the webhook server serves at.
 	WebhookPort int
 
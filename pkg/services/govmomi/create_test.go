@@ -48,8 +48,10 @@ func TestCreate(t *testing.T) {
 
 	authSession, err := session.GetOrCreate(
 		vmContext,
-		vmContext.VSphereVM.Spec.Server, "",
-		s.URL.User.Username(), pass)
+		session.NewParams().
+			WithServer(vmContext.VSphereVM.Spec.Server).
+			WithInsecure(true).
+			WithUserInfo(s.URL.User.Username(), pass))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -17,24 +17,232 @@ limitations under the License.
 package extra
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/base64"
+	"io/ioutil"
+	"strings"
 
+	"github.com/pkg/errors"
 	"github.com/vmware/govmomi/vim25/types"
 )
 
+const (
+	// DefaultUserDataKey is the default guestinfo key for user data.
+	DefaultUserDataKey = "guestinfo.userdata"
+
+	// DefaultMetadataKey is the default guestinfo key for meta data.
+	DefaultMetadataKey = "guestinfo.metadata"
+
+	// DefaultVendorDataKey is the default guestinfo key for vendor data.
+	DefaultVendorDataKey = "guestinfo.vendordata"
+
+	// DefaultIgnitionKey is the default guestinfo key for an Ignition
+	// config.
+	DefaultIgnitionKey = "guestinfo.ignition.config.data"
+
+	// encodingSuffix is appended to a data key to form the key at which the
+	// data's encoding is recorded.
+	encodingSuffix = ".encoding"
+)
+
 // Config is data used with a VM's guestInfo RPC interface.
 type Config []types.BaseOptionValue
 
+// KeyConfig overrides the guestinfo keys at which data is stored, for guest
+// agents that read from a non-default namespace. Empty fields keep the
+// default keys.
+type KeyConfig struct {
+	// UserData replaces DefaultUserDataKey.
+	UserData string
+
+	// Metadata replaces DefaultMetadataKey.
+	Metadata string
+
+	// VendorData replaces DefaultVendorDataKey.
+	VendorData string
+
+	// Ignition replaces DefaultIgnitionKey.
+	Ignition string
+}
+
+// ApplyKeyConfig renames the default data keys, and their encoding keys, to
+// the ones specified by the given KeyConfig. It should be called after the
+// data has been set.
+func (e *Config) ApplyKeyConfig(keys KeyConfig) {
+	renames := map[string]string{}
+	for defaultKey, key := range map[string]string{
+		DefaultUserDataKey:   keys.UserData,
+		DefaultMetadataKey:   keys.Metadata,
+		DefaultVendorDataKey: keys.VendorData,
+		DefaultIgnitionKey:   keys.Ignition,
+	} {
+		if key == "" || key == defaultKey {
+			continue
+		}
+		renames[defaultKey] = key
+		renames[defaultKey+encodingSuffix] = key + encodingSuffix
+	}
+	for _, v := range *e {
+		ov := v.GetOptionValue()
+		if key, ok := renames[ov.Key]; ok {
+			ov.Key = key
+		}
+	}
+}
+
+// DecodeFromVM returns a Config from a VM's extra config values. Values
+// with a recognized encoding are decoded to plain-text and their encoding
+// keys are dropped, so the result may be compared with the data that was
+// originally set. Values that cannot be decoded are returned as-is.
+func DecodeFromVM(values []types.BaseOptionValue) Config {
+	encodings := map[string]string{}
+	for _, v := range values {
+		ov := v.GetOptionValue()
+		if value, ok := ov.Value.(string); ok && strings.HasSuffix(ov.Key, encodingSuffix) {
+			encodings[strings.TrimSuffix(ov.Key, encodingSuffix)] = value
+		}
+	}
+
+	decodedKeys := map[string]bool{}
+	config := make(Config, 0, len(values))
+	for _, v := range values {
+		ov := v.GetOptionValue()
+		value, ok := ov.Value.(string)
+		if encoding, hasEncoding := encodings[ov.Key]; ok && hasEncoding {
+			if decoded, err := decode(value, encoding); err == nil {
+				value = decoded
+				decodedKeys[ov.Key] = true
+			}
+		}
+		config = append(config, &types.OptionValue{Key: ov.Key, Value: value})
+	}
+
+	// Drop the encoding keys of the values that were decoded.
+	filtered := config[:0]
+	for _, v := range config {
+		key := v.GetOptionValue().Key
+		if strings.HasSuffix(key, encodingSuffix) && decodedKeys[strings.TrimSuffix(key, encodingSuffix)] {
+			continue
+		}
+		filtered = append(filtered, v)
+	}
+	return filtered
+}
+
+// Get returns the value for the given key and whether the key was found.
+// If the key was set more than once, the last value wins, as it does when
+// the config is applied to a VM.
+func (e Config) Get(key string) (string, bool) {
+	var (
+		value string
+		found bool
+	)
+	for _, v := range e {
+		ov := v.GetOptionValue()
+		if ov.Key != key {
+			continue
+		}
+		s, ok := ov.Value.(string)
+		if !ok {
+			continue
+		}
+		value, found = s, true
+	}
+	return value, found
+}
+
+// Diff returns the option values in the config whose keys are missing from
+// current, or whose values differ from the ones in current. Values are
+// compared in their encoded form. An empty result means applying the
+// config would not change the VM, so the reconfigure may be skipped.
+func (e Config) Diff(current []types.BaseOptionValue) Config {
+	existing := make(map[string]interface{}, len(current))
+	for _, v := range current {
+		ov := v.GetOptionValue()
+		existing[ov.Key] = ov.Value
+	}
+
+	var diff Config
+	for _, v := range e {
+		ov := v.GetOptionValue()
+		if value, ok := existing[ov.Key]; ok && value == ov.Value {
+			continue
+		}
+		diff = append(diff, v)
+	}
+	return diff
+}
+
+// Dedup removes the option values whose keys are set again later in the
+// config, keeping the last value for each key as a VM would. The setters
+// append, so calling one twice leaves duplicate keys, and vCenter's handling
+// of duplicates in a single reconfigure is undefined. Dedup should be called
+// before the config is applied to a VM.
+func (e *Config) Dedup() {
+	seen := make(map[string]bool, len(*e))
+	deduped := make(Config, 0, len(*e))
+	for i := len(*e) - 1; i >= 0; i-- {
+		v := (*e)[i]
+		key := v.GetOptionValue().Key
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, v)
+	}
+	// Restore the original order of the values that were kept.
+	for i, j := 0, len(deduped)-1; i < j; i, j = i+1, j-1 {
+		deduped[i], deduped[j] = deduped[j], deduped[i]
+	}
+	*e = deduped
+}
+
 // SetCloudInitUserData sets the cloud init user data at the key
 // "guestinfo.userdata" as a base64-encoded string.
 func (e *Config) SetCloudInitUserData(data []byte) error {
 	*e = append(*e,
 		&types.OptionValue{
-			Key:   "guestinfo.userdata",
+			Key:   DefaultUserDataKey,
 			Value: e.encode(data),
 		},
 		&types.OptionValue{
-			Key:   "guestinfo.userdata.encoding",
+			Key:   DefaultUserDataKey + encodingSuffix,
+			Value: "base64",
+		},
+	)
+	return nil
+}
+
+// SetBootstrapData sets the cloud-init user data and the Ignition config,
+// for images that may boot with either. Each payload is written to its own
+// channel as a base64-encoded string: cloudInit at DefaultUserDataKey and
+// ignition at DefaultIgnitionKey, or at the keys they are renamed to by
+// ApplyKeyConfig. The image's active datasource reads the one it
+// understands. An error is returned if either is empty or if the config
+// already sets either default key.
+func (e *Config) SetBootstrapData(cloudInit, ignition []byte) error {
+	if len(cloudInit) == 0 {
+		return errors.New("cloud-init user data is empty")
+	}
+	if len(ignition) == 0 {
+		return errors.New("ignition config is empty")
+	}
+	for _, key := range []string{DefaultUserDataKey, DefaultIgnitionKey} {
+		if _, ok := e.Get(key); ok {
+			return errors.Errorf("%s is already set", key)
+		}
+	}
+	if err := e.SetCloudInitUserData(cloudInit); err != nil {
+		return err
+	}
+	*e = append(*e,
+		&types.OptionValue{
+			Key:   DefaultIgnitionKey,
+			Value: e.encode(ignition),
+		},
+		&types.OptionValue{
+			Key:   DefaultIgnitionKey + encodingSuffix,
 			Value: "base64",
 		},
 	)
@@ -46,11 +254,42 @@ func (e *Config) SetCloudInitUserData(data []byte) error {
 func (e *Config) SetCloudInitMetadata(data []byte) error {
 	*e = append(*e,
 		&types.OptionValue{
-			Key:   "guestinfo.metadata",
+			Key:   DefaultMetadataKey,
+			Value: e.encode(data),
+		},
+		&types.OptionValue{
+			Key:   DefaultMetadataKey + encodingSuffix,
+			Value: "base64",
+		},
+	)
+
+	return nil
+}
+
+// SetCloudInitMetadataIfAbsent is like SetCloudInitMetadata, but leaves the
+// config unchanged if current, the VM's extra config, already has non-empty
+// metadata, such as when a VM is adopted or upgraded with metadata that
+// should be kept. It returns whether the metadata was set.
+func (e *Config) SetCloudInitMetadataIfAbsent(data []byte, current []types.BaseOptionValue) (bool, error) {
+	if existing, ok := DecodeFromVM(current).Get(DefaultMetadataKey); ok && existing != "" {
+		return false, nil
+	}
+	if err := e.SetCloudInitMetadata(data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetCloudInitVendorData sets the cloud init vendor data at the key
+// "guestinfo.vendordata" as a base64-encoded string.
+func (e *Config) SetCloudInitVendorData(data []byte) error {
+	*e = append(*e,
+		&types.OptionValue{
+			Key:   DefaultVendorDataKey,
 			Value: e.encode(data),
 		},
 		&types.OptionValue{
-			Key:   "guestinfo.metadata.encoding",
+			Key:   DefaultVendorDataKey + encodingSuffix,
 			Value: "base64",
 		},
 	)
@@ -58,6 +297,92 @@ func (e *Config) SetCloudInitMetadata(data []byte) error {
 	return nil
 }
 
+// SetCloudInitUserDataGzip sets the cloud init user data at the key
+// "guestinfo.userdata" as a gzipped, base64-encoded string. This should
+// be used for payloads that would otherwise exceed the size limits vCenter
+// imposes on extra config values.
+func (e *Config) SetCloudInitUserDataGzip(data []byte) error {
+	return e.setGzip(DefaultUserDataKey, data)
+}
+
+// SetCloudInitMetadataGzip sets the cloud init meta data at the key
+// "guestinfo.metadata" as a gzipped, base64-encoded string.
+func (e *Config) SetCloudInitMetadataGzip(data []byte) error {
+	return e.setGzip(DefaultMetadataKey, data)
+}
+
+// setGzip sets the data at the given key as a gzipped, base64-encoded
+// string and records the encoding at the key's ".encoding" counterpart.
+func (e *Config) setGzip(key string, data []byte) error {
+	value, err := e.encodeGzip(data)
+	if err != nil {
+		return errors.Wrapf(err, "unable to compress %s", key)
+	}
+	*e = append(*e,
+		&types.OptionValue{
+			Key:   key,
+			Value: value,
+		},
+		&types.OptionValue{
+			Key:   key + encodingSuffix,
+			Value: "gzip+base64",
+		},
+	)
+	return nil
+}
+
+// encodeGzip first decodes the data to plain-text like encode, then
+// returns the gzipped result as a base64 encoded string.
+func (e *Config) encodeGzip(data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", nil
+	}
+	for {
+		decoded, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			break
+		}
+		data = decoded
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decode returns the plain-text form of a value with the given encoding.
+func decode(value, encoding string) (string, error) {
+	switch encoding {
+	case "base64":
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	case "gzip+base64":
+		compressed, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", err
+		}
+		r, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return "", err
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	default:
+		return "", errors.Errorf("unknown encoding %q", encoding)
+	}
+}
+
 // encode first attempts to decode the data as many times as necessary
 // to ensure it is plain-text before returning the result as a base64
 // encoded string
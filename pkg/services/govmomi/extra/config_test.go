@@ -0,0 +1,526 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package extra_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/types"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/services/govmomi/extra"
+)
+
+// optionValues returns the key/value pairs of the config as a map.
+func optionValues(e extra.Config) map[string]string {
+	values := map[string]string{}
+	for _, v := range e {
+		ov := v.GetOptionValue()
+		values[ov.Key] = ov.Value.(string)
+	}
+	return values
+}
+
+func gunzipBase64(t *testing.T, value string) string {
+	t.Helper()
+	compressed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatalf("failed to decode base64: %v", err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("failed to create gzip reader: %v", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to gunzip: %v", err)
+	}
+	return string(data)
+}
+
+func TestConfigGzip(t *testing.T) {
+	const data = "#cloud-config\nruncmd:\n- echo hello\n"
+
+	testCases := []struct {
+		name string
+		key  string
+		set  func(*extra.Config, []byte) error
+		data []byte
+	}{
+		{
+			name: "userdata",
+			key:  "guestinfo.userdata",
+			set:  (*extra.Config).SetCloudInitUserDataGzip,
+			data: []byte(data),
+		},
+		{
+			name: "userdata already base64-encoded",
+			key:  "guestinfo.userdata",
+			set:  (*extra.Config).SetCloudInitUserDataGzip,
+			data: []byte(base64.StdEncoding.EncodeToString([]byte(data))),
+		},
+		{
+			name: "metadata",
+			key:  "guestinfo.metadata",
+			set:  (*extra.Config).SetCloudInitMetadataGzip,
+			data: []byte(data),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var config extra.Config
+			if err := tc.set(&config, tc.data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			values := optionValues(config)
+			if actual := values[tc.key+".encoding"]; actual != "gzip+base64" {
+				t.Errorf("expected encoding %q, got %q", "gzip+base64", actual)
+			}
+			if actual := gunzipBase64(t, values[tc.key]); actual != data {
+				t.Errorf("expected data %q, got %q", data, actual)
+			}
+		})
+	}
+}
+
+func TestConfigSetCloudInitVendorData(t *testing.T) {
+	const data = "#cloud-config\nmanage_etc_hosts: true\n"
+
+	var config extra.Config
+	if err := config.SetCloudInitVendorData([]byte(data)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(config) != 2 {
+		t.Fatalf("expected 2 option values, got %d", len(config))
+	}
+	values := optionValues(config)
+	if actual := values["guestinfo.vendordata.encoding"]; actual != "base64" {
+		t.Errorf("expected encoding %q, got %q", "base64", actual)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(values["guestinfo.vendordata"])
+	if err != nil {
+		t.Fatalf("failed to decode base64: %v", err)
+	}
+	if string(decoded) != data {
+		t.Errorf("expected data %q, got %q", data, string(decoded))
+	}
+}
+
+func TestConfigSetCloudInitMetadataIfAbsent(t *testing.T) {
+	const metadata = "instance-id: test-vm\n"
+
+	testCases := []struct {
+		name     string
+		current  []types.BaseOptionValue
+		expected bool
+	}{
+		{
+			name:     "no extra config",
+			current:  nil,
+			expected: true,
+		},
+		{
+			name: "other keys only",
+			current: []types.BaseOptionValue{
+				&types.OptionValue{Key: extra.DefaultUserDataKey, Value: "dXNlcmRhdGE="},
+			},
+			expected: true,
+		},
+		{
+			name: "empty metadata",
+			current: []types.BaseOptionValue{
+				&types.OptionValue{Key: extra.DefaultMetadataKey, Value: ""},
+				&types.OptionValue{Key: extra.DefaultMetadataKey + ".encoding", Value: "base64"},
+			},
+			expected: true,
+		},
+		{
+			name: "existing metadata",
+			current: []types.BaseOptionValue{
+				&types.OptionValue{Key: extra.DefaultMetadataKey, Value: base64.StdEncoding.EncodeToString([]byte("instance-id: adopted\n"))},
+				&types.OptionValue{Key: extra.DefaultMetadataKey + ".encoding", Value: "base64"},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var config extra.Config
+			set, err := config.SetCloudInitMetadataIfAbsent([]byte(metadata), tc.current)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if set != tc.expected {
+				t.Errorf("expected set to be %t, got %t", tc.expected, set)
+			}
+			value, ok := optionValues(config)[extra.DefaultMetadataKey]
+			if !tc.expected {
+				if len(config) != 0 {
+					t.Errorf("expected the config to be unchanged, got %v", optionValues(config))
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("expected %s to be set", extra.DefaultMetadataKey)
+			}
+			if decoded, _ := base64.StdEncoding.DecodeString(value); string(decoded) != metadata {
+				t.Errorf("expected metadata %q, got %q", metadata, decoded)
+			}
+		})
+	}
+}
+
+func TestConfigSetBootstrapData(t *testing.T) {
+	const (
+		cloudInit = "#cloud-config\nruncmd:\n- echo hello\n"
+		ignition  = `{"ignition":{"version":"3.0.0"}}`
+	)
+
+	var config extra.Config
+	if err := config.SetBootstrapData([]byte(cloudInit), []byte(ignition)); err != nil {
+		t.Fatal(err)
+	}
+
+	values := optionValues(config)
+	for key, expected := range map[string]string{
+		extra.DefaultUserDataKey: cloudInit,
+		extra.DefaultIgnitionKey: ignition,
+	} {
+		value, ok := values[key]
+		if !ok {
+			t.Errorf("expected %s to be set", key)
+			continue
+		}
+		if decoded, _ := base64.StdEncoding.DecodeString(value); string(decoded) != expected {
+			t.Errorf("expected %s to be %q, got %q", key, expected, decoded)
+		}
+		if encoding := values[key+".encoding"]; encoding != "base64" {
+			t.Errorf("expected %s.encoding to be base64, got %q", key, encoding)
+		}
+	}
+
+	// Setting the data again would leave two values for each key.
+	if err := config.SetBootstrapData([]byte(cloudInit), []byte(ignition)); err == nil {
+		t.Error("expected an error when the keys are already set")
+	}
+	if err := (&extra.Config{}).SetBootstrapData([]byte(cloudInit), nil); err == nil {
+		t.Error("expected an error when the ignition config is empty")
+	}
+}
+
+func TestConfigApplyKeyConfigIgnition(t *testing.T) {
+	var config extra.Config
+	if err := config.SetBootstrapData([]byte("userdata"), []byte("ignition")); err != nil {
+		t.Fatal(err)
+	}
+
+	config.ApplyKeyConfig(extra.KeyConfig{
+		UserData: "guestinfo.acme.userdata",
+		Ignition: "guestinfo.acme.ignition.config.data",
+	})
+
+	expectedKeys := []string{
+		"guestinfo.acme.userdata",
+		"guestinfo.acme.userdata.encoding",
+		"guestinfo.acme.ignition.config.data",
+		"guestinfo.acme.ignition.config.data.encoding",
+	}
+	if len(config) != len(expectedKeys) {
+		t.Fatalf("expected %d option values, got %d", len(expectedKeys), len(config))
+	}
+	for i, v := range config {
+		if actual := v.GetOptionValue().Key; actual != expectedKeys[i] {
+			t.Errorf("expected key %q at index %d, got %q", expectedKeys[i], i, actual)
+		}
+	}
+}
+
+func TestConfigApplyKeyConfig(t *testing.T) {
+	testCases := []struct {
+		name         string
+		keys         extra.KeyConfig
+		expectedKeys []string
+	}{
+		{
+			name: "default keys",
+			expectedKeys: []string{
+				"guestinfo.userdata",
+				"guestinfo.userdata.encoding",
+				"guestinfo.metadata",
+				"guestinfo.metadata.encoding",
+				"guestinfo.vendordata",
+				"guestinfo.vendordata.encoding",
+				"guestinfo.custom",
+			},
+		},
+		{
+			name: "custom keys",
+			keys: extra.KeyConfig{
+				UserData:   "guestinfo.acme.userdata",
+				Metadata:   "guestinfo.acme.metadata",
+				VendorData: "guestinfo.acme.vendordata",
+			},
+			expectedKeys: []string{
+				"guestinfo.acme.userdata",
+				"guestinfo.acme.userdata.encoding",
+				"guestinfo.acme.metadata",
+				"guestinfo.acme.metadata.encoding",
+				"guestinfo.acme.vendordata",
+				"guestinfo.acme.vendordata.encoding",
+				"guestinfo.custom",
+			},
+		},
+		{
+			name: "custom user data key only",
+			keys: extra.KeyConfig{
+				UserData: "guestinfo.acme.userdata",
+			},
+			expectedKeys: []string{
+				"guestinfo.acme.userdata",
+				"guestinfo.acme.userdata.encoding",
+				"guestinfo.metadata",
+				"guestinfo.metadata.encoding",
+				"guestinfo.vendordata",
+				"guestinfo.vendordata.encoding",
+				"guestinfo.custom",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var config extra.Config
+			if err := config.SetCloudInitUserData([]byte("userdata")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := config.SetCloudInitMetadata([]byte("metadata")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := config.SetCloudInitVendorData([]byte("vendordata")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			config = append(config, &types.OptionValue{Key: "guestinfo.custom", Value: "value"})
+
+			config.ApplyKeyConfig(tc.keys)
+
+			if len(config) != len(tc.expectedKeys) {
+				t.Fatalf("expected %d option values, got %d", len(tc.expectedKeys), len(config))
+			}
+			for i, v := range config {
+				if actual := v.GetOptionValue().Key; actual != tc.expectedKeys[i] {
+					t.Errorf("expected key %q at index %d, got %q", tc.expectedKeys[i], i, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeFromVM(t *testing.T) {
+	const (
+		userdata = "#cloud-config\nruncmd:\n- echo hello\n"
+		metadata = "instance-id: test-vm\n"
+	)
+
+	var config extra.Config
+	if err := config.SetCloudInitUserDataGzip([]byte(userdata)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := config.SetCloudInitMetadata([]byte(metadata)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config = append(config,
+		&types.OptionValue{Key: "guestinfo.custom", Value: "value"},
+		&types.OptionValue{Key: "guestinfo.invalid", Value: "not base64!"},
+		&types.OptionValue{Key: "guestinfo.invalid.encoding", Value: "base64"},
+	)
+
+	decoded := extra.DecodeFromVM(config)
+
+	testCases := []struct {
+		key           string
+		expectedValue string
+		expectedFound bool
+	}{
+		{
+			key:           "guestinfo.userdata",
+			expectedValue: userdata,
+			expectedFound: true,
+		},
+		{
+			key:           "guestinfo.metadata",
+			expectedValue: metadata,
+			expectedFound: true,
+		},
+		{
+			key:           "guestinfo.userdata.encoding",
+			expectedFound: false,
+		},
+		{
+			key:           "guestinfo.metadata.encoding",
+			expectedFound: false,
+		},
+		{
+			key:           "guestinfo.custom",
+			expectedValue: "value",
+			expectedFound: true,
+		},
+		{
+			key:           "guestinfo.invalid",
+			expectedValue: "not base64!",
+			expectedFound: true,
+		},
+		{
+			key:           "guestinfo.invalid.encoding",
+			expectedValue: "base64",
+			expectedFound: true,
+		},
+		{
+			key:           "guestinfo.missing",
+			expectedFound: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.key, func(t *testing.T) {
+			value, found := decoded.Get(tc.key)
+			if found != tc.expectedFound {
+				t.Fatalf("expected found=%v, got %v", tc.expectedFound, found)
+			}
+			if value != tc.expectedValue {
+				t.Errorf("expected value %q, got %q", tc.expectedValue, value)
+			}
+		})
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	var desired extra.Config
+	if err := desired.SetCloudInitUserData([]byte("userdata")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := desired.SetCloudInitMetadata([]byte("metadata")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var changed extra.Config
+	if err := changed.SetCloudInitUserData([]byte("old userdata")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := changed.SetCloudInitMetadata([]byte("metadata")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var partial extra.Config
+	if err := partial.SetCloudInitUserData([]byte("userdata")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name         string
+		current      []types.BaseOptionValue
+		expectedKeys []string
+	}{
+		{
+			name: "no change",
+			current: append([]types.BaseOptionValue{
+				&types.OptionValue{Key: "guestinfo.custom", Value: "value"},
+			}, desired...),
+			expectedKeys: nil,
+		},
+		{
+			name:         "value change",
+			current:      changed,
+			expectedKeys: []string{"guestinfo.userdata"},
+		},
+		{
+			name:    "new keys",
+			current: partial,
+			expectedKeys: []string{
+				"guestinfo.metadata",
+				"guestinfo.metadata.encoding",
+			},
+		},
+		{
+			name:    "no current values",
+			current: nil,
+			expectedKeys: []string{
+				"guestinfo.userdata",
+				"guestinfo.userdata.encoding",
+				"guestinfo.metadata",
+				"guestinfo.metadata.encoding",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			diff := desired.Diff(tc.current)
+			if len(diff) != len(tc.expectedKeys) {
+				t.Fatalf("expected %d option values, got %d", len(tc.expectedKeys), len(diff))
+			}
+			for i, v := range diff {
+				if actual := v.GetOptionValue().Key; actual != tc.expectedKeys[i] {
+					t.Errorf("expected key %q at index %d, got %q", tc.expectedKeys[i], i, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigDedup(t *testing.T) {
+	var config extra.Config
+	if err := config.SetCloudInitUserData([]byte("first")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := config.SetCloudInitMetadata([]byte("metadata")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := config.SetCloudInitUserData([]byte("second")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config.Dedup()
+
+	expectedKeys := []string{
+		"guestinfo.metadata",
+		"guestinfo.metadata.encoding",
+		"guestinfo.userdata",
+		"guestinfo.userdata.encoding",
+	}
+	if len(config) != len(expectedKeys) {
+		t.Fatalf("expected %d option values, got %d", len(expectedKeys), len(config))
+	}
+	for i, v := range config {
+		if actual := v.GetOptionValue().Key; actual != expectedKeys[i] {
+			t.Errorf("expected key %q at index %d, got %q", expectedKeys[i], i, actual)
+		}
+	}
+	userData, _ := config.Get(extra.DefaultUserDataKey)
+	if expected := base64.StdEncoding.EncodeToString([]byte("second")); userData != expected {
+		t.Errorf("expected the last user data %q, got %q", expected, userData)
+	}
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package extra
+
+import (
+	"github.com/pkg/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/util"
+)
+
+// BuildGuestInfoConfig returns the config that passes the bootstrap data
+// and the machine's metadata to cloud-init. The metadata is rendered by
+// util.GetMachineMetadata with the given hostname and network status. No
+// user data is set if bootstrapData is empty.
+func BuildGuestInfoConfig(bootstrapData []byte, hostname string, machine infrav1.VSphereVM, networkStatus ...infrav1.NetworkStatus) (Config, error) {
+	var config Config
+	if len(bootstrapData) > 0 {
+		if err := config.SetCloudInitUserData(bootstrapData); err != nil {
+			return nil, errors.Wrapf(err, "unable to set user data for %q", hostname)
+		}
+	}
+
+	metadata, err := util.GetMachineMetadata(hostname, machine, networkStatus...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to render metadata for %q", hostname)
+	}
+	if err := config.SetCloudInitMetadata(metadata); err != nil {
+		return nil, errors.Wrapf(err, "unable to set metadata for %q", hostname)
+	}
+	return config, nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package extra_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/services/govmomi/extra"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestBuildGuestInfoConfig(t *testing.T) {
+	machine := infrav1.VSphereVM{
+		Spec: infrav1.VSphereVMSpec{
+			VirtualMachineCloneSpec: infrav1.VirtualMachineCloneSpec{
+				Network: infrav1.NetworkSpec{
+					Devices: []infrav1.NetworkDeviceSpec{
+						{
+							NetworkName: "network1",
+							IPAddrs:     []string{"192.168.4.21/24"},
+							Gateway4:    "192.168.4.1",
+							Nameservers: []string{"1.1.1.1"},
+						},
+						{
+							NetworkName: "network2",
+							DHCP4:       true,
+						},
+					},
+				},
+			},
+		},
+	}
+	networkStatus := []infrav1.NetworkStatus{
+		{MACAddr: "00:50:56:00:00:01"},
+		{MACAddr: "00:50:56:00:00:02"},
+	}
+	bootstrapData := []byte("#cloud-config\nruncmd:\n- kubeadm join\n")
+
+	config, err := extra.BuildGuestInfoConfig(bootstrapData, "test-vm", machine, networkStatus...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Record the encoding of each value, then the decoded values, so that
+	// the golden file is both exact and readable.
+	var actual bytes.Buffer
+	for _, v := range config {
+		ov := v.GetOptionValue()
+		if strings.HasSuffix(ov.Key, ".encoding") {
+			fmt.Fprintf(&actual, "%s = %s\n", ov.Key, ov.Value)
+		}
+	}
+	for _, v := range extra.DecodeFromVM(config) {
+		ov := v.GetOptionValue()
+		fmt.Fprintf(&actual, "\n--- %s ---\n%s", ov.Key, ov.Value)
+	}
+
+	golden := filepath.Join("testdata", "guestinfo.golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, actual.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual.Bytes(), expected) {
+		t.Errorf("guestinfo config does not match %s, run the test with -update to regenerate it:\n%s", golden, actual.String())
+	}
+}
+
+func TestBuildGuestInfoConfigWithoutBootstrapData(t *testing.T) {
+	config, err := extra.BuildGuestInfoConfig(nil, "test-vm", infrav1.VSphereVM{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := config.Get(extra.DefaultUserDataKey); ok {
+		t.Errorf("expected no user data, got %v", optionValues(config))
+	}
+	if _, ok := config.Get(extra.DefaultMetadataKey); !ok {
+		t.Errorf("expected metadata, got %v", optionValues(config))
+	}
+}
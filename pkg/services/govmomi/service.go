@@ -37,7 +37,6 @@ import (
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/context"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/services/govmomi/extra"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/services/govmomi/net"
-	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/util"
 )
 
 // VMService provdes API to interact with the VMs using govmomi
@@ -220,18 +219,20 @@ func (vms *VMService) reconcileMetadata(ctx *virtualMachineContext) (bool, error
 		return false, err
 	}
 
-	newMetadata, err := util.GetMachineMetadata(ctx.VSphereVM.Name, *ctx.VSphereVM, ctx.State.Network...)
+	extraConfig, err := extra.BuildGuestInfoConfig(nil, ctx.VSphereVM.Name, *ctx.VSphereVM, ctx.State.Network...)
 	if err != nil {
-		return false, err
+		return false, errors.Wrapf(err, "unable to build metadata for vm %s", ctx)
 	}
 
-	// If the metadata is the same then return early.
-	if string(newMetadata) == existingMetadata {
+	// If the metadata is the same then return early. The config is decoded
+	// so that the metadata compared is the one that would be written.
+	newMetadata, _ := extra.DecodeFromVM(extraConfig).Get(extra.DefaultMetadataKey)
+	if newMetadata == existingMetadata {
 		return true, nil
 	}
 
 	ctx.Logger.Info("updating metadata")
-	taskRef, err := vms.setMetadata(ctx, newMetadata)
+	taskRef, err := vms.setMetadata(ctx, extraConfig)
 	if err != nil {
 		return false, errors.Wrapf(err, "unable to set metadata on vm %s", ctx)
 	}
@@ -339,12 +340,7 @@ func (vms *VMService) getMetadata(ctx *virtualMachineContext) (string, error) {
 	return string(metadataBuf), nil
 }
 
-func (vms *VMService) setMetadata(ctx *virtualMachineContext, metadata []byte) (string, error) {
-	var extraConfig extra.Config
-	if err := extraConfig.SetCloudInitMetadata(metadata); err != nil {
-		return "", errors.Wrapf(err, "unable to set metadata on vm %s", ctx)
-	}
-
+func (vms *VMService) setMetadata(ctx *virtualMachineContext, extraConfig extra.Config) (string, error) {
 	task, err := ctx.Obj.Reconfigure(ctx, types.VirtualMachineConfigSpec{
 		ExtraConfig: extraConfig,
 	})
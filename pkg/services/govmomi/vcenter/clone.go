@@ -23,6 +23,7 @@ import (
 	"github.com/vmware/govmomi/vim25/types"
 
 	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/constants"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/context"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/services/govmomi/extra"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/services/govmomi/template"
@@ -101,11 +102,6 @@ func Clone(ctx *context.VMContext, bootstrapData []byte) error {
 		return errors.Wrapf(err, "unable to get folder for %q", ctx)
 	}
 
-	datastore, err := ctx.Session.Finder.DatastoreOrDefault(ctx, ctx.VSphereVM.Spec.Datastore)
-	if err != nil {
-		return errors.Wrapf(err, "unable to get datastore for %q", ctx)
-	}
-
 	pool, err := ctx.Session.Finder.ResourcePoolOrDefault(ctx, ctx.VSphereVM.Spec.ResourcePool)
 	if err != nil {
 		return errors.Wrapf(err, "unable to get resource pool for %q", ctx)
@@ -161,7 +157,6 @@ func Clone(ctx *context.VMContext, bootstrapData []byte) error {
 			MemoryMB:          memMiB,
 		},
 		Location: types.VirtualMachineRelocateSpec{
-			Datastore:    types.NewReference(datastore.Reference()),
 			DiskMoveType: string(diskMoveType),
 			Folder:       types.NewReference(folder.Reference()),
 			Pool:         types.NewReference(pool.Reference()),
@@ -174,6 +169,12 @@ func Clone(ctx *context.VMContext, bootstrapData []byte) error {
 		Snapshot: snapshotRef,
 	}
 
+	datastore, err := getDatastore(ctx, tpl, folder, &spec)
+	if err != nil {
+		return err
+	}
+	spec.Location.Datastore = &datastore
+
 	ctx.Logger.Info("cloning machine", "namespace", ctx.VSphereVM.Namespace, "name", ctx.VSphereVM.Name, "cloneType", ctx.VSphereVM.Status.CloneMode)
 	task, err := tpl.Clone(ctx, folder, ctx.VSphereVM.Name, spec)
 	if err != nil {
@@ -191,6 +192,51 @@ func Clone(ctx *context.VMContext, bootstrapData []byte) error {
 	return nil
 }
 
+// getDatastore returns the datastore to which the VM is cloned. If the
+// VSphereVM's failure domain placed it in a datastore cluster, the datastore
+// recommended by Storage DRS for the clone spec is used. Otherwise the
+// VSphereVM's datastore, or the default datastore, is used.
+func getDatastore(ctx *context.VMContext, tpl *object.VirtualMachine, folder *object.Folder, spec *types.VirtualMachineCloneSpec) (types.ManagedObjectReference, error) {
+	datastoreCluster := ctx.VSphereVM.Annotations[constants.DatastoreClusterAnnotationLabel]
+	if datastoreCluster == "" {
+		datastore, err := ctx.Session.Finder.DatastoreOrDefault(ctx, ctx.VSphereVM.Spec.Datastore)
+		if err != nil {
+			return types.ManagedObjectReference{}, errors.Wrapf(err, "unable to get datastore for %q", ctx)
+		}
+		return datastore.Reference(), nil
+	}
+
+	pod, err := ctx.Session.Finder.DatastoreCluster(ctx, datastoreCluster)
+	if err != nil {
+		return types.ManagedObjectReference{}, errors.Wrapf(err, "unable to get datastore cluster %q for %q", datastoreCluster, ctx)
+	}
+	podRef := pod.Reference()
+	folderRef := folder.Reference()
+	tplRef := tpl.Reference()
+	placement := types.StoragePlacementSpec{
+		Type:      string(types.StoragePlacementSpecPlacementTypeClone),
+		CloneName: ctx.VSphereVM.Name,
+		CloneSpec: spec,
+		Folder:    &folderRef,
+		Vm:        &tplRef,
+		PodSelectionSpec: types.StorageDrsPodSelectionSpec{
+			StoragePod: &podRef,
+		},
+	}
+	result, err := object.NewStorageResourceManager(ctx.Session.Client.Client).RecommendDatastores(ctx, placement)
+	if err != nil {
+		return types.ManagedObjectReference{}, errors.Wrapf(err, "unable to get datastore recommendations from datastore cluster %q for %q", datastoreCluster, ctx)
+	}
+	for _, recommendation := range result.Recommendations {
+		for _, action := range recommendation.Action {
+			if placementAction, ok := action.(*types.StoragePlacementAction); ok {
+				return placementAction.Destination, nil
+			}
+		}
+	}
+	return types.ManagedObjectReference{}, errors.Errorf("no datastore recommended by datastore cluster %q for %q", datastoreCluster, ctx)
+}
+
 func newVMFlagInfo() *types.VirtualMachineFlagInfo {
 	diskUUIDEnabled := true
 	return &types.VirtualMachineFlagInfo{
@@ -19,12 +19,16 @@ package vcenter
 import (
 	ctx "context"
 	"crypto/tls"
+	"strings"
 	"testing"
 
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/soap"
 	"github.com/vmware/govmomi/vim25/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/constants"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/context"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/session"
 )
@@ -128,6 +132,147 @@ func TestGetDiskSpec(t *testing.T) {
 	}
 }
 
+// podStorageResourceManager recommends the datastores of the storage pod
+// in the placement spec when no per-disk placement is given, as vCenter
+// does. The simulator only recommends datastores for per-disk placements.
+type podStorageResourceManager struct {
+	*simulator.StorageResourceManager
+}
+
+func (m *podStorageResourceManager) RecommendDatastores(req *types.RecommendDatastores) soap.HasFault {
+	spec := &req.StorageSpec.PodSelectionSpec
+	if len(spec.InitialVmConfig) == 0 && spec.StoragePod != nil {
+		spec.InitialVmConfig = []types.VmPodConfigForPlacement{{StoragePod: *spec.StoragePod}}
+	}
+	return m.StorageResourceManager.RecommendDatastores(req)
+}
+
+func TestGetDatastore(t *testing.T) {
+	model := simulator.VPX()
+	model.Host = 0
+	model.Pod = 1
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	defer model.Remove()
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	pass, _ := server.URL.User.Password()
+	s, err := session.GetOrCreate(
+		ctx.TODO(),
+		session.NewParams().
+			WithServer(server.URL.Host).
+			WithInsecure(true).
+			WithUserInfo(server.URL.User.Username(), pass).
+			WithDatacenter("DC0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srm := simulator.Map.Get(*s.Client.ServiceContent.StorageResourceManager).(*simulator.StorageResourceManager)
+	simulator.Map.Put(&podStorageResourceManager{srm})
+
+	// Place the only datastore in the datastore cluster.
+	datastore, err := s.Finder.Datastore(ctx.TODO(), "LocalDS_0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod, err := s.Finder.DatastoreCluster(ctx.TODO(), "DC0_POD0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := pod.MoveInto(ctx.TODO(), []types.ManagedObjectReference{datastore.Reference()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Wait(ctx.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	// The simulator also leaves the datastore, twice, in its old folder.
+	storagePod := simulator.Map.Get(pod.Reference()).(*simulator.StoragePod)
+	datastoreFolder := simulator.Map.Get(*storagePod.Parent).(*simulator.Folder)
+	for i := 0; i < 2; i++ {
+		simulator.RemoveReference(&datastoreFolder.ChildEntity, datastore.Reference())
+	}
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	tpl := object.NewVirtualMachine(s.Client.Client, vm.Reference())
+	folder, err := s.Finder.DefaultFolder(ctx.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name              string
+		datastore         string
+		datastoreCluster  string
+		expectedDatastore types.ManagedObjectReference
+		err               string
+	}{
+		{
+			name:              "default datastore",
+			expectedDatastore: datastore.Reference(),
+		},
+		{
+			name:              "named datastore",
+			datastore:         "LocalDS_0",
+			expectedDatastore: datastore.Reference(),
+		},
+		{
+			name:              "datastore recommended by Storage DRS",
+			datastoreCluster:  "DC0_POD0",
+			expectedDatastore: datastore.Reference(),
+		},
+		{
+			name:             "datastore cluster not found",
+			datastoreCluster: "DC0_POD1",
+			err:              `unable to get datastore cluster "DC0_POD1"`,
+		},
+	}
+
+	for _, test := range testCases {
+		tc := test
+		t.Run(tc.name, func(t *testing.T) {
+			vsphereVM := &v1alpha3.VSphereVM{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-vm",
+				},
+				Spec: v1alpha3.VSphereVMSpec{
+					VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+						Datastore: tc.datastore,
+					},
+				},
+			}
+			if tc.datastoreCluster != "" {
+				vsphereVM.Annotations = map[string]string{
+					constants.DatastoreClusterAnnotationLabel: tc.datastoreCluster,
+				}
+			}
+			vmContext := &context.VMContext{
+				ControllerContext: &context.ControllerContext{
+					ControllerManagerContext: &context.ControllerManagerContext{Context: ctx.TODO()},
+				},
+				VSphereVM: vsphereVM,
+				Session:   s,
+			}
+			actual, err := getDatastore(vmContext, tpl, folder, &types.VirtualMachineCloneSpec{})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Expected to get %q error from getDatastore, got: '%v'", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error from getDatastore: %v", err)
+			}
+			if actual != tc.expectedDatastore {
+				t.Errorf("Expected datastore %v, got %v", tc.expectedDatastore, actual)
+			}
+		})
+	}
+}
+
 func initSimulator(t *testing.T) (*simulator.Model, *session.Session, *simulator.Server) {
 	model := simulator.VPX()
 	model.Host = 0
@@ -143,8 +288,10 @@ func initSimulator(t *testing.T) (*simulator.Model, *session.Session, *simulator
 
 	authSession, err := session.GetOrCreate(
 		ctx.TODO(),
-		server.URL.Host, "",
-		server.URL.User.Username(), pass)
+		session.NewParams().
+			WithServer(server.URL.Host).
+			WithInsecure(true).
+			WithUserInfo(server.URL.User.Username(), pass))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,173 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake provides an in-memory implementation of session.ObjectFinder
+// for use in tests only. It does not connect to a vSphere server.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/session"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/util"
+)
+
+// Session is an in-memory session.ObjectFinder seeded with AddVM and
+// AddObject. The zero value is ready to use, and it is safe for concurrent
+// use. Like a real session, lookups by UUID return a nil reference and no
+// error if nothing matches.
+type Session struct {
+	mu      sync.Mutex
+	nextID  int
+	vms     []vm
+	objects map[string]types.ManagedObjectReference
+}
+
+var _ session.ObjectFinder = &Session{}
+
+// vm is a VM seeded by AddVM.
+type vm struct {
+	biosUUID     string
+	instanceUUID string
+	ref          types.ManagedObjectReference
+}
+
+// objectTypes are the managed object types of the kinds resolved by
+// FindObjectByPath.
+var objectTypes = map[string]string{
+	session.ObjectKindDatastore:    "Datastore",
+	session.ObjectKindFolder:       "Folder",
+	session.ObjectKindResourcePool: "ResourcePool",
+	session.ObjectKindNetwork:      "Network",
+}
+
+// NewSession returns an empty fake session.
+func NewSession() *Session {
+	return &Session{}
+}
+
+// AddVM seeds a VM with the given BIOS and instance UUIDs and returns its
+// reference. Either UUID may be empty if the VM should not be found by it.
+func (s *Session) AddVM(biosUUID, instanceUUID string) types.ManagedObjectReference {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ref := s.newRef("VirtualMachine", "vm")
+	s.vms = append(s.vms, vm{
+		biosUUID:     strings.ToLower(biosUUID),
+		instanceUUID: strings.ToLower(instanceUUID),
+		ref:          ref,
+	})
+	return ref
+}
+
+// AddObject seeds an object of the given kind, one of the session
+// package's ObjectKind constants, that is found by the given path, and
+// returns its reference. An object found by both a name and an inventory
+// path must be added once for each.
+func (s *Session) AddObject(kind, path string) types.ManagedObjectReference {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	typ, ok := objectTypes[kind]
+	if !ok {
+		panic(fmt.Sprintf("unsupported object kind %q", kind))
+	}
+	ref := s.newRef(typ, strings.ToLower(typ))
+	if s.objects == nil {
+		s.objects = map[string]types.ManagedObjectReference{}
+	}
+	s.objects[kind+"/"+path] = ref
+	return ref
+}
+
+// newRef returns a reference of the given type with a unique ID. s.mu
+// must be held.
+func (s *Session) newRef(typ, prefix string) types.ManagedObjectReference {
+	s.nextID++
+	return types.ManagedObjectReference{Type: typ, Value: fmt.Sprintf("%s-%d", prefix, s.nextID)}
+}
+
+// FindByBIOSUUID implements session.ObjectFinder.
+func (s *Session) FindByBIOSUUID(ctx context.Context, uuid string) (object.Reference, error) {
+	return s.findByUUID(uuid, false), nil
+}
+
+// FindByInstanceUUID implements session.ObjectFinder.
+func (s *Session) FindByInstanceUUID(ctx context.Context, uuid string) (object.Reference, error) {
+	return s.findByUUID(uuid, true), nil
+}
+
+// FindByProviderID implements session.ObjectFinder.
+func (s *Session) FindByProviderID(ctx context.Context, providerID string) (object.Reference, error) {
+	uuid, instanceUUID, err := util.ParseProviderIDKind(&providerID)
+	if err != nil {
+		return nil, err
+	}
+	return s.findByUUID(uuid, instanceUUID), nil
+}
+
+// FindAllByInstanceUUID implements session.ObjectFinder.
+func (s *Session) FindAllByInstanceUUID(ctx context.Context, uuids []string) (map[string]object.Reference, error) {
+	refs := make(map[string]object.Reference, len(uuids))
+	for _, uuid := range uuids {
+		refs[uuid] = s.findByUUID(uuid, true)
+	}
+	return refs, nil
+}
+
+// FindObjectByPath implements session.ObjectFinder.
+func (s *Session) FindObjectByPath(ctx context.Context, kind, path string) (object.Reference, error) {
+	if _, ok := objectTypes[kind]; !ok {
+		return nil, errors.Errorf("unsupported object kind %q", kind)
+	}
+	if path == "" {
+		return nil, errors.Errorf("%s path is empty", kind)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ref, ok := s.objects[kind+"/"+path]
+	if !ok {
+		return nil, &session.ObjectNotFoundError{
+			Kind: kind,
+			Path: path,
+			Err:  errors.Errorf("%s '%s' not found", strings.ToLower(kind), path),
+		}
+	}
+	return object.NewReference(nil, ref), nil
+}
+
+// findByUUID returns the VM with the given BIOS or instance UUID, or nil
+// if there is none.
+func (s *Session) findByUUID(uuid string, instanceUUID bool) object.Reference {
+	uuid = strings.ToLower(uuid)
+	if uuid == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, vm := range s.vms {
+		if (instanceUUID && vm.instanceUUID == uuid) || (!instanceUUID && vm.biosUUID == uuid) {
+			return object.NewVirtualMachine(nil, vm.ref)
+		}
+	}
+	return nil
+}
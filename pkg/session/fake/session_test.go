@@ -0,0 +1,103 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/session"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/session/fake"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/util"
+)
+
+func TestSessionFindVM(t *testing.T) {
+	const (
+		biosUUID     = "265104de-1472-547c-b873-6dc7883fb6cb"
+		instanceUUID = "5009a1d4-7a4b-4b48-8a8b-1b9b0b1d2e3f"
+	)
+	ctx := context.Background()
+	s := fake.NewSession()
+	expected := s.AddVM(biosUUID, instanceUUID)
+	s.AddVM("", "00000000-0000-0000-0000-000000000000")
+
+	// The finder is used through the interface, as code under test would.
+	var finder session.ObjectFinder = s
+
+	byBIOS, err := finder.FindByBIOSUUID(ctx, biosUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byBIOS == nil || byBIOS.Reference() != expected {
+		t.Errorf("expected %v by BIOS UUID, got %v", expected, byBIOS)
+	}
+
+	byInstance, err := finder.FindByInstanceUUID(ctx, "5009A1D4-7A4B-4B48-8A8B-1B9B0B1D2E3F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byInstance == nil || byInstance.Reference() != expected {
+		t.Errorf("expected %v by instance UUID, got %v", expected, byInstance)
+	}
+
+	byProviderID, err := finder.FindByProviderID(ctx, util.ConvertInstanceUUIDToProviderID(instanceUUID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byProviderID == nil || byProviderID.Reference() != expected {
+		t.Errorf("expected %v by provider ID, got %v", expected, byProviderID)
+	}
+
+	// A BIOS UUID does not match an instance UUID.
+	missing, err := finder.FindByInstanceUUID(ctx, biosUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing != nil {
+		t.Errorf("expected no VM, got %v", missing)
+	}
+
+	all, err := finder.FindAllByInstanceUUID(ctx, []string{instanceUUID, biosUUID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all[instanceUUID] == nil || all[instanceUUID].Reference() != expected || all[biosUUID] != nil {
+		t.Errorf("unexpected VMs %v", all)
+	}
+}
+
+func TestSessionFindObjectByPath(t *testing.T) {
+	ctx := context.Background()
+	s := fake.NewSession()
+	expected := s.AddObject(session.ObjectKindDatastore, "/DC0/datastore/LocalDS_0")
+
+	ref, err := s.FindObjectByPath(ctx, session.ObjectKindDatastore, "/DC0/datastore/LocalDS_0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Reference() != expected {
+		t.Errorf("expected %v, got %v", expected, ref.Reference())
+	}
+
+	_, err = s.FindObjectByPath(ctx, session.ObjectKindDatastore, "LocalDS_0")
+	var notFound *session.ObjectNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Errorf("expected an ObjectNotFoundError, got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/utils/clock"
+)
+
+// janitorClock is the clock that measures how long cached sessions have
+// been idle. Tests replace it to evict sessions without waiting.
+var janitorClock clock.Clock = clock.RealClock{}
+
+// janitorInterval is how often the cache is checked for idle sessions.
+var janitorInterval = time.Minute
+
+// janitorLogoutTimeout bounds logging out of an evicted session.
+var janitorLogoutTimeout = 30 * time.Second
+
+// janitorOnce starts the janitor the first time a session with an idle TTL
+// is cached.
+var janitorOnce sync.Once
+
+// sessionLastUsed records when each cached session was last returned by
+// GetOrCreate, keyed like sessionCache. It is guarded by sessionMU, and
+// only has entries for keys that are in sessionCache.
+var sessionLastUsed = map[string]time.Time{}
+
+// startJanitor starts the janitor if the session described by params has
+// an idle TTL and the janitor is not already running.
+func startJanitor(params *Params) {
+	if params.idleTTL > 0 {
+		janitorOnce.Do(func() {
+			go runJanitor(janitorClock)
+		})
+	}
+}
+
+// runJanitor evicts idle sessions every janitorInterval, as measured by
+// clk. It runs for the life of the process.
+func runJanitor(clk clock.Clock) {
+	for {
+		<-clk.After(janitorInterval)
+		evictIdleSessions(clk.Now())
+	}
+}
+
+// evictIdleSessions removes the cached sessions that have not been used
+// for longer than their idle TTL and logs them out. Sessions without an
+// idle TTL are kept.
+func evictIdleSessions(now time.Time) {
+	var idle []Session
+	sessionMU.Lock()
+	for sessionKey, session := range sessionCache {
+		ttl := session.params.idleTTL
+		if ttl <= 0 || now.Sub(sessionLastUsed[sessionKey]) < ttl {
+			continue
+		}
+		delete(sessionCache, sessionKey)
+		delete(sessionLastUsed, sessionKey)
+		idle = append(idle, session)
+	}
+	sessionMU.Unlock()
+
+	for i := range idle {
+		session := &idle[i]
+		logger := session.params.log()
+		logger.V(2).Info("evicted idle vSphere client session", "idle-ttl", session.params.idleTTL.String())
+		ctx, cancel := context.WithTimeout(context.Background(), janitorLogoutTimeout)
+		if err := session.Close(ctx); err != nil {
+			logger.Error(err, "error logging out of idle vSphere client session")
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package session
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestJanitorEvictsIdleSession(t *testing.T) {
+	const ttl = 10 * time.Minute
+	clock := clocktesting.NewFakeClock(time.Now())
+	original := janitorClock
+	janitorClock = clock
+	defer func() { janitorClock = original }()
+	// Start a janitor driven by the fake clock. One started by an earlier
+	// run waits on that run's clock forever.
+	janitorOnce = sync.Once{}
+
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	params := NewParams().
+		WithServer(server.URL.Host).
+		WithInsecure(true).
+		WithUserInfo(server.URL.User.Username(), pass).
+		WithIdleTTL(ttl)
+	s, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := func() bool {
+		sessionMU.Lock()
+		defer sessionMU.Unlock()
+		_, ok := sessionCache[params.key()]
+		return ok
+	}
+
+	// step advances the clock and waits for the janitor to finish the
+	// pass it triggers.
+	step := func(d time.Duration) {
+		eventually(t, clock.HasWaiters)
+		clock.Step(d)
+		eventually(t, clock.HasWaiters)
+	}
+
+	// A session used within the TTL is kept.
+	step(ttl / 2)
+	if !cached() {
+		t.Fatal("expected the session to remain cached before the idle TTL")
+	}
+	if _, err := GetOrCreate(ctx, params); err != nil {
+		t.Fatal(err)
+	}
+	step(ttl/2 + time.Minute)
+	if !cached() {
+		t.Fatal("expected using the session to reset its idle time")
+	}
+
+	// Once idle beyond the TTL, the session is evicted and logged out.
+	step(ttl / 2)
+	if cached() {
+		t.Fatal("expected the idle session to be evicted")
+	}
+	userSession, err := s.SessionManager.UserSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userSession != nil {
+		t.Error("expected the idle session to be logged out")
+	}
+}
+
+func TestIdleTTLSessionIdentity(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	newParams := func() *Params {
+		return NewParams().
+			WithServer(server.URL.Host).
+			WithInsecure(true).
+			WithUserInfo(server.URL.User.Username(), pass)
+	}
+	params := newParams()
+	ttlParams := newParams().WithIdleTTL(time.Hour)
+	if params.key() == ttlParams.key() {
+		t.Fatal("expected the idle TTL to be part of the session key")
+	}
+
+	s, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttlSession, err := GetOrCreate(ctx, ttlParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Evict(ttlParams)
+	if s.Client == ttlSession.Client {
+		t.Error("expected a session with a different idle TTL not to be shared")
+	}
+	if ttlSession.params.idleTTL != time.Hour {
+		t.Errorf("expected idle TTL %s, got %s", time.Hour, ttlSession.params.idleTTL)
+	}
+
+	// Closing the session removes its last-use time along with it.
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sessionMU.Lock()
+	_, used := sessionLastUsed[params.key()]
+	sessionMU.Unlock()
+	if used {
+		t.Error("expected the closed session's last-use time to be removed")
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"k8s.io/utils/clock"
+)
+
+// keepAliveClock is the clock that schedules keepalive requests. Tests
+// replace it to drive keepalives without waiting.
+var keepAliveClock clock.Clock = clock.RealClock{}
+
+// keepAlive is a soap.RoundTripper that invokes a handler every idle
+// interval while the client is logged in. It behaves like govmomi's
+// keepalive.HandlerSOAP, but schedules the handler with a clock.Clock.
+type keepAlive struct {
+	roundTripper soap.RoundTripper
+	idle         time.Duration
+	clock        clock.Clock
+	send         func() error
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newKeepAlive returns a keepAlive that wraps roundTripper and passes it to
+// handler every idle interval, as measured by clk. The keepalive stops when
+// the handler returns an error or the client logs out.
+func newKeepAlive(roundTripper soap.RoundTripper, idle time.Duration, clk clock.Clock, handler func(soap.RoundTripper) error) *keepAlive {
+	return &keepAlive{
+		roundTripper: roundTripper,
+		idle:         idle,
+		clock:        clk,
+		send: func() error {
+			return handler(roundTripper)
+		},
+	}
+}
+
+// RoundTrip implements soap.RoundTripper. The keepalive is started by a
+// successful login and stopped by a logout.
+func (k *keepAlive) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	if _, ok := req.(*methods.LogoutBody); ok {
+		k.Stop()
+	}
+
+	if err := k.roundTripper.RoundTrip(ctx, req, res); err != nil {
+		return err
+	}
+
+	switch req.(type) {
+	case *methods.LoginBody, *methods.LoginExtensionByCertificateBody, *methods.LoginByTokenBody:
+		k.Start()
+	}
+	return nil
+}
+
+// Start starts invoking the handler. It does nothing if the keepalive is
+// already running.
+func (k *keepAlive) Start() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.stop != nil {
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	k.stop, k.done = stop, done
+
+	go func() {
+		defer close(done)
+		timer := k.clock.NewTimer(k.idle)
+		for {
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-timer.C():
+				if err := k.send(); err != nil {
+					k.release(stop)
+					return
+				}
+				timer.Reset(k.idle)
+			}
+		}
+	}()
+}
+
+// Stop stops invoking the handler and waits for an invocation in progress
+// to return. The lock is not held while waiting: the handler may log in
+// again through this round tripper, which calls Start.
+func (k *keepAlive) Stop() {
+	k.mu.Lock()
+	stop, done := k.stop, k.done
+	k.stop, k.done = nil, nil
+	k.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// release marks the keepalive identified by stop as no longer running,
+// unless it has already been stopped or replaced.
+func (k *keepAlive) release(stop chan struct{}) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.stop == stop {
+		k.stop, k.done = nil, nil
+	}
+}
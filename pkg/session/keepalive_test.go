@@ -0,0 +1,200 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package session
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// nopRoundTripper is a soap.RoundTripper whose requests always succeed.
+type nopRoundTripper struct{}
+
+func (nopRoundTripper) RoundTrip(context.Context, soap.HasFault, soap.HasFault) error {
+	return nil
+}
+
+// eventually fails the test if cond does not become true. It only waits
+// for the keepalive goroutine to be scheduled; time is advanced by the
+// fake clock.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		return cond(), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeepAliveCycles(t *testing.T) {
+	const idle = 5 * time.Minute
+	clock := clocktesting.NewFakeClock(time.Now())
+
+	var sends int32
+	k := newKeepAlive(nopRoundTripper{}, idle, clock, func(soap.RoundTripper) error {
+		if atomic.AddInt32(&sends, 1) == 3 {
+			return errors.New("keepalive failed")
+		}
+		return nil
+	})
+
+	ctx := context.Background()
+	if err := k.RoundTrip(ctx, &methods.LoginBody{}, &methods.LoginBody{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for cycle := int32(1); cycle <= 3; cycle++ {
+		eventually(t, clock.HasWaiters)
+
+		// The handler is not invoked before the idle interval elapses.
+		clock.Step(idle / 2)
+		if n := atomic.LoadInt32(&sends); n != cycle-1 {
+			t.Fatalf("expected %d keepalive(s) after half an interval, got %d", cycle-1, n)
+		}
+		if !clock.HasWaiters() {
+			t.Fatal("expected the keepalive to still be waiting")
+		}
+
+		clock.Step(idle / 2)
+		eventually(t, func() bool { return atomic.LoadInt32(&sends) == cycle })
+	}
+
+	// The keepalive stops once the handler fails.
+	eventually(t, func() bool {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		return k.stop == nil
+	})
+	if clock.HasWaiters() {
+		t.Error("expected the keepalive to stop after the handler failed")
+	}
+}
+
+func TestKeepAliveStopsOnLogout(t *testing.T) {
+	const idle = 5 * time.Minute
+	clock := clocktesting.NewFakeClock(time.Now())
+
+	var sends int32
+	k := newKeepAlive(nopRoundTripper{}, idle, clock, func(soap.RoundTripper) error {
+		atomic.AddInt32(&sends, 1)
+		return nil
+	})
+
+	ctx := context.Background()
+	if err := k.RoundTrip(ctx, &methods.LoginBody{}, &methods.LoginBody{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, clock.HasWaiters)
+
+	if err := k.RoundTrip(ctx, &methods.LogoutBody{}, &methods.LogoutBody{}); err != nil {
+		t.Fatal(err)
+	}
+	if clock.HasWaiters() {
+		t.Error("expected the keepalive to stop after logging out")
+	}
+	clock.Step(idle)
+	if n := atomic.LoadInt32(&sends); n != 0 {
+		t.Errorf("expected no keepalives after logging out, got %d", n)
+	}
+}
+
+func TestKeepAliveStopDuringRelogin(t *testing.T) {
+	const idle = 5 * time.Minute
+	clock := clocktesting.NewFakeClock(time.Now())
+
+	// The handler stands in for a keepalive that logs in again, which
+	// restarts the keepalive through its own round tripper.
+	entered, relogin := make(chan struct{}), make(chan struct{})
+	var k *keepAlive
+	k = newKeepAlive(nopRoundTripper{}, idle, clock, func(soap.RoundTripper) error {
+		close(entered)
+		<-relogin
+		k.Start()
+		return nil
+	})
+
+	ctx := context.Background()
+	if err := k.RoundTrip(ctx, &methods.LoginBody{}, &methods.LoginBody{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, clock.HasWaiters)
+	clock.Step(idle)
+	<-entered
+
+	stopped := make(chan struct{})
+	go func() {
+		k.Stop()
+		close(stopped)
+	}()
+	eventually(t, func() bool {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		return k.stop == nil
+	})
+	close(relogin)
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Stop to return while the handler logs in again")
+	}
+	k.Stop()
+}
+
+func TestKeepAliveEvictsSession(t *testing.T) {
+	clock := clocktesting.NewFakeClock(time.Now())
+	original := keepAliveClock
+	keepAliveClock = clock
+	defer func() { keepAliveClock = original }()
+
+	model, server := newSimulator(t)
+	defer model.Remove()
+
+	pass, _ := server.URL.User.Password()
+	params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)
+	if _, err := GetOrCreate(context.Background(), params); err != nil {
+		t.Fatal(err)
+	}
+	cached := func() bool {
+		sessionMU.Lock()
+		defer sessionMU.Unlock()
+		_, ok := sessionCache[params.key()]
+		return ok
+	}
+
+	// A keepalive while the server is up keeps the session cached.
+	eventually(t, clock.HasWaiters)
+	clock.Step(keepAliveDuration)
+	eventually(t, clock.HasWaiters)
+	if !cached() {
+		t.Fatal("expected the session to remain cached after a successful keepalive")
+	}
+
+	// Once the server stops, the keepalive cannot log back in and the
+	// session is evicted.
+	server.Close()
+	clock.Step(keepAliveDuration)
+	eventually(t, func() bool { return !cached() })
+}
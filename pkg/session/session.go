@@ -18,82 +18,1273 @@ package session
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
 	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	govmomisession "github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/sts"
+	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/sync/singleflight"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/version"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 
 	"sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/util"
 )
 
+// ErrThumbprintChanged is returned, wrapped, when the vSphere server
+// presents a certificate that does not match the session's thumbprints,
+// such as after the server's certificate is rotated. The session may be
+// updated with RefreshThumbprint rather than evicted.
+var ErrThumbprintChanged = errors.New("vSphere server thumbprint changed")
+
+// ErrSessionExpired is returned, wrapped, when the vSphere server no longer
+// recognizes the session, such as after it expired on the server. The
+// session has been removed from the cache, so calling GetOrCreate again
+// logs in anew.
+var ErrSessionExpired = errors.New("vSphere session expired")
+
+// The kinds of objects that FindObjectByPath resolves.
+const (
+	ObjectKindDatastore    = "Datastore"
+	ObjectKindFolder       = "Folder"
+	ObjectKindResourcePool = "ResourcePool"
+	ObjectKindNetwork      = "Network"
+)
+
+// ObjectNotFoundError is returned by FindObjectByPath when no object of
+// the requested kind has the given name or path.
+type ObjectNotFoundError struct {
+	Kind string
+	Path string
+	Err  error
+}
+
+func (e *ObjectNotFoundError) Error() string {
+	return fmt.Sprintf("%s %q not found: %v", e.Kind, e.Path, e.Err)
+}
+
+// Unwrap returns the error reported by the finder.
+func (e *ObjectNotFoundError) Unwrap() error {
+	return e.Err
+}
+
 var sessionCache = map[string]Session{}
 var sessionMU sync.Mutex
 
+// keepAliveDuration is how long a session may be idle before a keepalive
+// request is sent to the vSphere server.
+var keepAliveDuration = 5 * time.Minute
+
+// sessionGroup collapses concurrent attempts to create the same session
+// into a single login.
+var sessionGroup singleflight.Group
+
+// sharedLoginTimeout bounds a login shared by concurrent GetOrCreate calls.
+// The login is not tied to any one caller's context, so that a caller that
+// gives up does not fail the others.
+var sharedLoginTimeout = 5 * time.Minute
+
 // Session is a vSphere session with a configured Finder.
 type Session struct {
 	*govmomi.Client
-	Finder     *find.Finder
-	datacenter *object.Datacenter
+	Finder      *find.Finder
+	datacenter  *object.Datacenter
+	sessionKey  string
+	params      Params
+	rest        *restSession
+	datacenters *datacenterCache
+	verifier    *thumbprintVerifier
+
+	// ctx, when set by WithContext, bounds the session's lookups in
+	// addition to the context given to each call.
+	ctx context.Context
 }
 
-// GetOrCreate gets a cached session or creates a new one if one does not
-// already exist.
-func GetOrCreate(
-	ctx context.Context,
-	server, datacenter, username, password string) (*Session, error) {
+// datacenterCache holds the datacenters resolved by a Session, keyed by the
+// name or path used to look them up. It is shared by all copies of a
+// Session.
+type datacenterCache struct {
+	mu          sync.Mutex
+	datacenters map[string]*object.Datacenter
+}
 
-	sessionMU.Lock()
-	defer sessionMU.Unlock()
+func (c *datacenterCache) get(name string) (*object.Datacenter, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dc, ok := c.datacenters[name]
+	return dc, ok
+}
+
+func (c *datacenterCache) set(name string, dc *object.Datacenter) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.datacenters == nil {
+		c.datacenters = map[string]*object.Datacenter{}
+	}
+	c.datacenters[name] = dc
+}
+
+func (c *datacenterCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.datacenters = nil
+}
 
-	sessionKey := server + username + datacenter
-	if session, ok := sessionCache[sessionKey]; ok {
-		if ok, _ := session.SessionManager.SessionIsActive(ctx); ok {
-			return &session, nil
+// thumbprintVerifier verifies the vSphere server's certificate against a
+// set of thumbprints that may be replaced while the session is in use. It
+// is shared by all copies of a Session.
+type thumbprintVerifier struct {
+	mu          sync.Mutex
+	thumbprints []string
+}
+
+func (v *thumbprintVerifier) set(thumbprints ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.thumbprints = thumbprints
+}
+
+// verify fails unless the SHA-1 thumbprint of the peer's leaf certificate
+// matches one of the verifier's thumbprints. A mismatch is reported as
+// ErrThumbprintChanged.
+func (v *thumbprintVerifier) verify(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("vSphere server presented no certificates")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return errors.Wrap(err, "error parsing vSphere server certificate")
+	}
+	v.mu.Lock()
+	thumbprints := v.thumbprints
+	v.mu.Unlock()
+	peer := soap.ThumbprintSHA1(cert)
+	for _, thumbprint := range thumbprints {
+		if strings.EqualFold(peer, thumbprint) {
+			return nil
 		}
 	}
+	return errors.Wrapf(ErrThumbprintChanged, "vSphere server thumbprint %q does not match %q", peer, strings.Join(thumbprints, ", "))
+}
+
+// restSession lazily logs into the vSphere REST API. It is shared by all
+// copies of a Session.
+type restSession struct {
+	mu     sync.Mutex
+	client *rest.Client
+}
+
+// Params are the parameters used to create or look up a vSphere session.
+type Params struct {
+	server      string
+	datacenter  string
+	userinfo    *url.Userinfo
+	tokenSigner *sts.Signer
+
+	credentialProvider func(ctx context.Context) (username, password string, err error)
+
+	thumbprint  string
+	thumbprints map[string]string
+	caCerts     []byte
+	insecure    bool
+	proxyURL    string
+	userAgent   string
+
+	loginAttempts int
+	loginBackoff  time.Duration
+	loginTimeout  time.Duration
+	logger        logr.Logger
+
+	maxConnections int
+	idleTTL        time.Duration
+}
+
+// NewParams returns an empty set of parameters.
+func NewParams() *Params {
+	return &Params{}
+}
+
+// WithServer sets the vSphere server to which to connect.
+func (p *Params) WithServer(server string) *Params {
+	p.server = server
+	return p
+}
+
+// WithDatacenter sets the datacenter used to scope the session's Finder.
+func (p *Params) WithDatacenter(datacenter string) *Params {
+	p.datacenter = datacenter
+	return p
+}
+
+// WithUserInfo sets the credentials used to log into the vSphere server.
+func (p *Params) WithUserInfo(username, password string) *Params {
+	p.userinfo = url.UserPassword(username, password)
+	return p
+}
+
+// WithCredentialProvider sets a function that returns the credentials used
+// to log into the vSphere server. It is called each time the session logs
+// in, including when the keepalive logs back in, so that credentials read
+// from a rotating secret need not be fixed when the session is created.
+// The provider is only called to log in, and sessions created with it are
+// cached without regard to the credentials it returns, so rotating them
+// keeps the session. Parameters that differ only in their provider share a
+// session.
+func (p *Params) WithCredentialProvider(provider func(ctx context.Context) (username, password string, err error)) *Params {
+	p.credentialProvider = provider
+	return p
+}
+
+// WithTokenSigner sets a signer holding a SAML token issued by an STS. When
+// set, the session logs in with the token instead of the user information.
+func (p *Params) WithTokenSigner(signer *sts.Signer) *Params {
+	p.tokenSigner = signer
+	return p
+}
+
+// WithThumbprint sets the SHA-1 thumbprint used to verify the vSphere
+// server's certificate.
+func (p *Params) WithThumbprint(thumbprint string) *Params {
+	p.thumbprint = thumbprint
+	return p
+}
+
+// WithThumbprints sets the SHA-1 thumbprints, keyed by host, used to verify
+// the certificates of the vSphere servers. The certificate presented by a
+// server is accepted if it matches any of the thumbprints, including the
+// one set with WithThumbprint, so that several servers with different
+// certificates may sit behind a single load balanced address.
+func (p *Params) WithThumbprints(thumbprints map[string]string) *Params {
+	p.thumbprints = make(map[string]string, len(thumbprints))
+	for host, thumbprint := range thumbprints {
+		p.thumbprints[host] = thumbprint
+	}
+	return p
+}
+
+// allowedThumbprints returns the thumbprints set with WithThumbprint and
+// WithThumbprints, sorted so that the result is stable.
+func (p *Params) allowedThumbprints() []string {
+	var thumbprints []string
+	if p.thumbprint != "" {
+		thumbprints = append(thumbprints, p.thumbprint)
+	}
+	for _, thumbprint := range p.thumbprints {
+		if thumbprint != "" {
+			thumbprints = append(thumbprints, thumbprint)
+		}
+	}
+	sort.Strings(thumbprints)
+	return thumbprints
+}
+
+// WithCACerts sets a PEM-encoded bundle of CA certificates used to verify
+// the vSphere server's certificate. The bundle takes precedence over the
+// thumbprint when both are set.
+func (p *Params) WithCACerts(caCerts []byte) *Params {
+	p.caCerts = caCerts
+	return p
+}
+
+// WithInsecure sets whether to skip verifying the vSphere server's
+// certificate when neither a CA bundle nor a thumbprint is set. By default
+// the certificate is verified against the system's trusted roots. A CA
+// bundle or thumbprint is always used to verify the certificate when set.
+func (p *Params) WithInsecure(insecure bool) *Params {
+	p.insecure = insecure
+	return p
+}
+
+// WithProxy sets the URL of an HTTP or HTTPS proxy through which to
+// connect to the vSphere server. The proxy is otherwise determined by the
+// environment.
+func (p *Params) WithProxy(proxyURL string) *Params {
+	p.proxyURL = proxyURL
+	return p
+}
+
+// WithUserAgent sets the user agent the session presents to the vSphere
+// server, so that sessions may be attributed to a controller in vCenter's
+// logs. The API group version is used by default.
+func (p *Params) WithUserAgent(userAgent string) *Params {
+	p.userAgent = userAgent
+	return p
+}
+
+// WithLoginRetry sets how many times logging in is attempted and how long
+// to wait before the first retry. The wait doubles after each attempt.
+func (p *Params) WithLoginRetry(attempts int, backoff time.Duration) *Params {
+	p.loginAttempts = attempts
+	p.loginBackoff = backoff
+	return p
+}
+
+// WithLoginTimeout sets how long each attempt to log in may take.
+func (p *Params) WithLoginTimeout(timeout time.Duration) *Params {
+	p.loginTimeout = timeout
+	return p
+}
+
+// WithMaxConnections sets the maximum number of connections, idle or in
+// use, the session opens to the vSphere server. The number is not limited
+// by default.
+func (p *Params) WithMaxConnections(n int) *Params {
+	p.maxConnections = n
+	return p
+}
+
+// WithIdleTTL sets how long the session may go unused before it is
+// removed from the cache and logged out, so that sessions for deleted
+// clusters do not accumulate. The TTL is part of the cached session's
+// identity, so callers with different TTLs do not share a session. Sessions
+// are kept until they fail by default.
+func (p *Params) WithIdleTTL(d time.Duration) *Params {
+	p.idleTTL = d
+	return p
+}
+
+// WithLogger sets the logger used to report the session's lifecycle, such
+// as cache hits and misses, logins, and evictions. The controller-runtime
+// logger is used by default.
+func (p *Params) WithLogger(logger logr.Logger) *Params {
+	p.logger = logger
+	return p
+}
+
+// Validate returns an error if the parameters are missing the server or
+// the credentials with which to log in. Either user information, a
+// credential provider, or a token signer is required.
+func (p *Params) Validate() error {
+	if p.server == "" {
+		return errors.New("invalid session parameters: server is required")
+	}
+	if p.userinfo == nil && p.tokenSigner == nil && p.credentialProvider == nil {
+		return errors.New("invalid session parameters: userinfo is required")
+	}
+	return nil
+}
 
-	soapURL, err := soap.ParseURL(server)
+// key returns the key used to cache a session. A hash of the credentials,
+// the means of verifying the server, the proxy, the user agent, and the
+// idle TTL is included so that changing any of them results in a new
+// session rather than reusing one created with the old values. The
+// credentials from a credential provider are left out, so the key is the
+// same before and after the provider is called and rotating them keeps the
+// session.
+func (p *Params) key() string {
+	var username, password string
+	if p.userinfo != nil && p.credentialProvider == nil {
+		username = p.userinfo.Username()
+		password, _ = p.userinfo.Password()
+	}
+	var token string
+	if p.tokenSigner != nil {
+		username = tokenSubject(p.tokenSigner.Token)
+		token = p.tokenSigner.Token
+	}
+	h := sha256.New()
+	_, _ = h.Write([]byte(password))
+	_, _ = h.Write([]byte(token))
+	_, _ = h.Write([]byte(strings.Join(p.allowedThumbprints(), ",")))
+	_, _ = h.Write(p.caCerts)
+	_, _ = h.Write([]byte(strconv.FormatBool(p.insecure)))
+	_, _ = h.Write([]byte(p.proxyURL))
+	_, _ = h.Write([]byte(p.userAgent))
+	_, _ = h.Write([]byte(p.idleTTL.String()))
+	return p.server + username + p.datacenter + hex.EncodeToString(h.Sum(nil))
+}
+
+// label returns a description of the session suitable for reporting. It
+// does not include any secrets.
+func (p *Params) label() string {
+	label := p.username() + "@" + p.server
+	if p.datacenter != "" {
+		label += "/" + p.datacenter
+	}
+	return label
+}
+
+// username returns the name of the user as which the session logs in.
+func (p *Params) username() string {
+	if p.tokenSigner != nil {
+		return tokenSubject(p.tokenSigner.Token)
+	}
+	if p.userinfo != nil {
+		return p.userinfo.Username()
+	}
+	return ""
+}
+
+// log returns the session's logger with the server, datacenter, and user
+// as key/value pairs.
+func (p *Params) log() logr.Logger {
+	logger := p.logger
+	if logger == nil {
+		logger = ctrllog.Log.WithName("session")
+	}
+	return logger.WithValues("server", p.server, "datacenter", p.datacenter, "username", p.username())
+}
+
+// hasCredentials returns true if the parameters contain user information,
+// a credential provider, or a token with which to log in.
+func (p *Params) hasCredentials() bool {
+	return p.userinfo != nil || p.tokenSigner != nil || p.credentialProvider != nil
+}
+
+// provideCredentials returns a copy of the parameters whose user
+// information is fetched from the credential provider. The parameters are
+// returned unchanged if there is no provider.
+func (p *Params) provideCredentials(ctx context.Context) (*Params, error) {
+	if p.credentialProvider == nil {
+		return p, nil
+	}
+	username, password, err := p.credentialProvider(ctx)
 	if err != nil {
-		return nil, errors.Wrapf(err, "error parsing vSphere URL %q", server)
+		return nil, errors.Wrapf(err, "error fetching credentials for vSphere server %q", p.server)
 	}
-	if soapURL == nil {
-		return nil, errors.Errorf("error parsing vSphere URL %q", server)
+	provided := *p
+	provided.userinfo = url.UserPassword(username, password)
+	return &provided, nil
+}
+
+// tokenSubject returns the principal named by a SAML token, or an empty
+// string if the token cannot be parsed.
+func tokenSubject(token string) string {
+	var assertion struct {
+		NameID string `xml:"Subject>NameID"`
+	}
+	if err := xml.Unmarshal([]byte(token), &assertion); err != nil {
+		return ""
+	}
+	return assertion.NameID
+}
+
+// Manager gets or creates vSphere sessions. Code that depends on a Manager
+// rather than on GetOrCreate may be tested with a fake implementation.
+type Manager interface {
+	// GetOrCreate gets a cached session or creates a new one if one does
+	// not already exist.
+	GetOrCreate(ctx context.Context, params *Params) (*Session, error)
+}
+
+// ObjectFinder looks up vSphere objects by UUID, provider ID, or inventory
+// path. It is implemented by *Session, and by the in-memory fake in
+// pkg/session/fake for tests of code that only needs to find objects.
+type ObjectFinder interface {
+	FindByBIOSUUID(ctx context.Context, uuid string) (object.Reference, error)
+	FindByInstanceUUID(ctx context.Context, uuid string) (object.Reference, error)
+	FindByProviderID(ctx context.Context, providerID string) (object.Reference, error)
+	FindAllByInstanceUUID(ctx context.Context, uuids []string) (map[string]object.Reference, error)
+	FindObjectByPath(ctx context.Context, kind, path string) (object.Reference, error)
+}
+
+var _ ObjectFinder = &Session{}
+
+// cachingManager is the Manager used by GetOrCreate. It caches sessions in
+// the package's session cache.
+type cachingManager struct{}
+
+// defaultManager is the Manager to which GetOrCreate delegates.
+var defaultManager Manager = cachingManager{}
+
+// NewManager returns a Manager that caches sessions like GetOrCreate.
+func NewManager() Manager {
+	return defaultManager
+}
+
+// GetOrCreate gets a cached session or creates a new one if one does not
+// already exist. Concurrent calls for the same parameters share a single
+// login, which is not cancelled when one of the callers' contexts is.
+func GetOrCreate(ctx context.Context, params *Params) (*Session, error) {
+	return defaultManager.GetOrCreate(ctx, params)
+}
+
+// GetOrCreate implements Manager.
+func (cachingManager) GetOrCreate(ctx context.Context, params *Params) (*Session, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Do not bother looking up or creating a session for a caller that has
+	// already given up.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	logger := params.log()
+	sessionKey := params.key()
+	if session, ok := getCachedSession(ctx, sessionKey); ok {
+		logger.V(2).Info("found cached vSphere client session")
+		startJanitor(params)
+		return session, nil
+	}
+	logger.V(2).Info("no cached vSphere client session")
+
+	// The login may outlive this call, so it uses a copy of the parameters.
+	shared := *params
+	results := sessionGroup.DoChan(sessionKey, func() (interface{}, error) {
+		loginCtx, cancel := context.WithTimeout(context.Background(), sharedLoginTimeout)
+		defer cancel()
+
+		// The session may have been cached by a call that completed after
+		// the cache miss above but before this call joined the group.
+		if session, ok := getCachedSession(loginCtx, sessionKey); ok {
+			return session, nil
+		}
+
+		provided, err := shared.provideCredentials(loginCtx)
+		if err != nil {
+			return nil, err
+		}
+		session, err := newSession(loginCtx, provided)
+		if err != nil {
+			return nil, err
+		}
+
+		// Cache the session.
+		sessionMU.Lock()
+		sessionCache[sessionKey] = *session
+		sessionLastUsed[sessionKey] = janitorClock.Now()
+		sessionMU.Unlock()
+
+		logger.V(2).Info("cached vSphere client session")
+
+		return session, nil
+	})
+
+	// Wait for the shared login, unless this caller gives up first. The
+	// login continues for the other callers, and its session is cached.
+	var result singleflight.Result
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if result.Err != nil {
+		return nil, result.Err
 	}
+	startJanitor(params)
+
+	// Return a copy so callers sharing the result do not share a pointer.
+	session := *result.Val.(*Session)
+	return &session, nil
+}
 
-	soapURL.User = url.UserPassword(username, password)
+// getCachedSession returns the cached session for the given key if one
+// exists and is still active. A session that is found is recorded as used.
+func getCachedSession(ctx context.Context, sessionKey string) (*Session, bool) {
+	sessionMU.Lock()
+	session, ok := sessionCache[sessionKey]
+	if ok {
+		sessionLastUsed[sessionKey] = janitorClock.Now()
+	}
+	sessionMU.Unlock()
+	if !ok {
+		return nil, false
+	}
+	if ok, _ := session.SessionManager.SessionIsActive(ctx); !ok {
+		return nil, false
+	}
+	return &session, true
+}
 
-	// Temporarily setting the insecure flag True
-	// TODO(ssurana): handle the certs better
-	client, err := govmomi.NewClient(ctx, soapURL, true)
+// newSession logs into the vSphere server described by params and returns
+// a session whose Finder is scoped to the requested datacenter.
+func newSession(ctx context.Context, params *Params) (*Session, error) {
+	client, verifier, err := newClient(ctx, params)
 	if err != nil {
-		return nil, errors.Wrapf(err, "error setting up new vSphere SOAP client")
+		return nil, err
 	}
 
-	session := Session{Client: client}
-	session.UserAgent = v1alpha3.GroupVersion.String()
+	session := Session{
+		Client:      client,
+		sessionKey:  params.key(),
+		params:      *params,
+		rest:        &restSession{},
+		datacenters: &datacenterCache{},
+		verifier:    verifier,
+	}
 
 	// Assign the finder to the session.
 	session.Finder = find.NewFinder(session.Client.Client, false)
 
 	// Assign the datacenter if one was specified.
-	dc, err := session.Finder.DatacenterOrDefault(ctx, datacenter)
+	dc, err := session.Finder.DatacenterOrDefault(ctx, params.datacenter)
 	if err != nil {
-		return nil, errors.Wrapf(err, "unable to find datacenter %q", datacenter)
+		return nil, errors.Wrapf(err, "unable to find datacenter %q", params.datacenter)
 	}
 	session.datacenter = dc
+	session.datacenters.set(params.datacenter, dc)
 	session.Finder.SetDatacenter(dc)
 
-	// Cache the session.
-	sessionCache[sessionKey] = session
+	return &session, nil
+}
 
-	// TODO(akutz) Reintroduce the logger.
-	//ctx.Logger.V(2).Info("cached vSphere client session", "server", server, "datacenter", datacenter)
+// newClient returns a SOAP client logged into the vSphere server described
+// by params. The server's certificate is verified against the CA bundle if
+// one is set, otherwise against the thumbprints if any are set, otherwise
+// against the system's trusted roots unless the parameters are insecure.
+// The verifier of the thumbprints is returned so that they may be
+// refreshed; it is nil if they are not used.
+func newClient(ctx context.Context, params *Params) (*govmomi.Client, *thumbprintVerifier, error) {
+	soapURL, err := soap.ParseURL(params.server)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error parsing vSphere URL %q", params.server)
+	}
+	if soapURL == nil {
+		return nil, nil, errors.Errorf("error parsing vSphere URL %q", params.server)
+	}
 
-	return &session, nil
+	thumbprints := params.allowedThumbprints()
+	insecure := params.insecure && len(thumbprints) == 0 && len(params.caCerts) == 0
+	soapClient := soap.NewClient(soapURL, insecure)
+	var verifier *thumbprintVerifier
+	switch {
+	case len(params.caCerts) > 0:
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(params.caCerts) {
+			return nil, nil, errors.New("error parsing vSphere CA certificates")
+		}
+		soapClient.DefaultTransport().TLSClientConfig.RootCAs = pool
+	case len(thumbprints) > 0:
+		// The thumbprints are verified here rather than with SetThumbprint
+		// because the soap client only falls back to the thumbprint for
+		// specific x509 error types, which newer versions of Go wrap. They
+		// are still registered so they are known to the client.
+		for host, thumbprint := range params.thumbprints {
+			soapClient.SetThumbprint(host, thumbprint)
+		}
+		tlsConfig := soapClient.DefaultTransport().TLSClientConfig
+		tlsConfig.InsecureSkipVerify = true
+		verifier = &thumbprintVerifier{}
+		verifier.set(thumbprints...)
+		tlsConfig.VerifyPeerCertificate = verifier.verify
+	}
+
+	if params.maxConnections > 0 {
+		transport := soapClient.DefaultTransport()
+		transport.MaxConnsPerHost = params.maxConnections
+		transport.MaxIdleConnsPerHost = params.maxConnections
+	}
+
+	// The user agent is set before logging in so that it is recorded
+	// with the session.
+	soapClient.UserAgent = params.userAgent
+	if soapClient.UserAgent == "" {
+		soapClient.UserAgent = v1alpha3.GroupVersion.String()
+	}
+
+	if err := setProxy(soapClient, params.proxyURL); err != nil {
+		return nil, nil, err
+	}
+
+	vimClient, err := vim25.NewClient(ctx, soapClient)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error setting up new vSphere SOAP client")
+	}
+
+	client := &govmomi.Client{Client: vimClient}
+	vimClient.RoundTripper = newKeepAlive(
+		vimClient.RoundTripper, keepAliveDuration, keepAliveClock, keepAliveHandler(client, params))
+	client.SessionManager = govmomisession.NewManager(vimClient)
+	// Only login if the parameters contain credentials.
+	if params.hasCredentials() {
+		if err := login(ctx, client, params); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return client, verifier, nil
+}
+
+// login logs the client into the vSphere server, retrying according to
+// the login retry parameters until an attempt succeeds or the context is
+// cancelled.
+func login(ctx context.Context, client *govmomi.Client, params *Params) error {
+	attempts := params.loginAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := params.loginBackoff
+
+	logger := params.log()
+	for attempt := 1; ; attempt++ {
+		err := loginOnce(ctx, client, params)
+		if err == nil {
+			logger.V(2).Info("logged into vSphere server", "attempt", attempt)
+			return nil
+		}
+		logger.V(2).Info("error logging into vSphere server", "attempt", attempt, "error", err.Error())
+		if attempt >= attempts {
+			return errors.Wrapf(err,
+				"error logging into vSphere server %q after %d attempt(s)",
+				params.server, attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(),
+				"error logging into vSphere server %q after %d attempt(s)",
+				params.server, attempt)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// loginOnce makes a single attempt to log the client in, bounded by the
+// login timeout if one is set.
+func loginOnce(ctx context.Context, client *govmomi.Client, params *Params) error {
+	if params.loginTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, params.loginTimeout)
+		defer cancel()
+	}
+	if params.tokenSigner != nil {
+		ctx = client.WithHeader(ctx, soap.Header{Security: params.tokenSigner})
+		return client.SessionManager.LoginByToken(ctx)
+	}
+	return client.Login(ctx, params.userinfo)
+}
+
+// setProxy routes the client's requests through the given proxy. The
+// client's default proxy is left in place if proxyURL is empty.
+func setProxy(client *soap.Client, proxyURL string) error {
+	if proxyURL == "" {
+		return nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing proxy URL %q", proxyURL)
+	}
+	client.DefaultTransport().Proxy = http.ProxyURL(u)
+	return nil
+}
+
+// keepAliveHandler returns the handler invoked when a session has been idle
+// for keepAliveDuration. If the keepalive request fails, the handler logs
+// back in with the session's credentials. The session is only removed from
+// the cache if logging back in also fails.
+func keepAliveHandler(client *govmomi.Client, params *Params) func(soap.RoundTripper) error {
+	// Copy the parameters so that later changes made by the caller do not
+	// affect the keepalive.
+	p := *params
+	sessionKey := p.key()
+	return func(tripper soap.RoundTripper) error {
+		// The keepalive outlives the context used to create the session.
+		ctx := context.Background()
+		_, err := methods.GetCurrentTime(ctx, tripper)
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, ErrThumbprintChanged) {
+			// Logging in again would fail the same way. Keep the session
+			// so that its thumbprint may be refreshed.
+			p.log().V(2).Info("vSphere session keepalive failed, server thumbprint changed", "error", err.Error())
+			return errors.Wrapf(err, "error keeping vSphere session alive for %q", p.server)
+		}
+		p.log().V(2).Info("vSphere session keepalive failed, logging in again", "error", err.Error())
+		if p.hasCredentials() {
+			// Fetch fresh credentials in case they were rotated since the
+			// last login.
+			var provided *Params
+			if provided, err = p.provideCredentials(ctx); err == nil {
+				p.userinfo = provided.userinfo
+				if err = login(ctx, client, &p); err == nil {
+					return nil
+				}
+			}
+		}
+		clearCache(sessionKey, client)
+		p.log().V(2).Info("evicted vSphere client session after failed keepalive")
+		return errors.Wrapf(err, "error keeping vSphere session alive for %q", p.server)
+	}
+}
+
+// clearCache removes the session cached under the given key if it uses
+// the given client. The session is looked up by its client if it has since
+// been cached under another key, such as by RefreshThumbprint.
+func clearCache(sessionKey string, client *govmomi.Client) {
+	sessionMU.Lock()
+	defer sessionMU.Unlock()
+	if cached, ok := sessionCache[sessionKey]; !ok || cached.Client != client {
+		if client == nil {
+			return
+		}
+		sessionKey = ""
+		for key, cached := range sessionCache {
+			if cached.Client == client {
+				sessionKey = key
+				break
+			}
+		}
+		if sessionKey == "" {
+			return
+		}
+	}
+	sessionCache[sessionKey].datacenters.clear()
+	delete(sessionCache, sessionKey)
+	delete(sessionLastUsed, sessionKey)
+}
+
+// Evict removes the session cached for the given parameters, if any, and
+// invalidates the datacenters it has resolved. The evicted session is not
+// logged out; use Close for that.
+func Evict(params *Params) {
+	sessionMU.Lock()
+	defer sessionMU.Unlock()
+	if cached, ok := sessionCache[params.key()]; ok {
+		cached.datacenters.clear()
+		params.log().V(2).Info("evicted vSphere client session")
+	}
+	delete(sessionCache, params.key())
+	delete(sessionLastUsed, params.key())
+}
+
+// Close logs the session out of the vSphere server and removes it from the
+// session cache. It is safe to call Close more than once.
+func (s *Session) Close(ctx context.Context) error {
+	clearCache(s.sessionKey, s.Client)
+	s.datacenters.clear()
+	s.params.log().V(2).Info("closing vSphere client session")
+
+	if s.rest != nil {
+		s.rest.mu.Lock()
+		restClient := s.rest.client
+		s.rest.client = nil
+		s.rest.mu.Unlock()
+		if restClient != nil {
+			if err := restClient.Logout(ctx); err != nil {
+				return errors.Wrapf(err, "error logging out of vSphere REST session")
+			}
+		}
+	}
+
+	if s.Client == nil {
+		return nil
+	}
+	if ok, _ := s.SessionManager.SessionIsActive(ctx); !ok {
+		return nil
+	}
+	if err := s.Logout(ctx); err != nil {
+		return errors.Wrapf(err, "error logging out of vSphere session")
+	}
+	return nil
+}
+
+// Healthy returns an error if the session can no longer talk to the
+// vSphere server. It issues the same request used to keep the session
+// alive.
+func (s *Session) Healthy(ctx context.Context) error {
+	if s.Client == nil {
+		return errors.New("vSphere client is not initialized")
+	}
+	if _, err := methods.GetCurrentTime(ctx, s.Client); err != nil {
+		return errors.Wrapf(err, "error checking health of vSphere session for %q", s.params.server)
+	}
+	return nil
+}
+
+// CheckAll checks the health of every cached session. The result is keyed
+// by a description of each session's user, server, and datacenter. When
+// more than one cached session has the same description, such as after a
+// password is rotated, the description is healthy if any of them are.
+func CheckAll(ctx context.Context) map[string]error {
+	sessionMU.Lock()
+	sessions := make([]Session, 0, len(sessionCache))
+	for _, session := range sessionCache {
+		sessions = append(sessions, session)
+	}
+	sessionMU.Unlock()
+
+	results := make(map[string]error, len(sessions))
+	for i := range sessions {
+		label := sessions[i].params.label()
+		if err, ok := results[label]; ok && err == nil {
+			continue
+		}
+		results[label] = sessions[i].Healthy(ctx)
+	}
+	return results
+}
+
+// ActiveSessionCount returns the number of sessions the vSphere server
+// reports as active, including those of other users and clients.
+func (s *Session) ActiveSessionCount(ctx context.Context) (int, error) {
+	if s.Client == nil {
+		return 0, errors.New("vSphere client is not initialized")
+	}
+	var sessionManager mo.SessionManager
+	pc := property.DefaultCollector(s.Client.Client)
+	if err := pc.RetrieveOne(ctx, *s.ServiceContent.SessionManager, []string{"sessionList"}, &sessionManager); err != nil {
+		return 0, errors.Wrapf(err, "error listing sessions on vSphere server %q", s.params.server)
+	}
+	return len(sessionManager.SessionList), nil
+}
+
+// ActiveSessionCounts returns the number of active sessions on each server
+// for which a session is cached, keyed by server. As every session on a
+// server reports the same list, each server is only queried once, through
+// the first of its cached sessions able to do so. If none of the cached
+// sessions for a server can, its error is aggregated with those of other
+// such servers, ordered by server.
+func ActiveSessionCounts(ctx context.Context) (map[string]int, error) {
+	sessionMU.Lock()
+	sessions := make([]Session, 0, len(sessionCache))
+	for _, session := range sessionCache {
+		sessions = append(sessions, session)
+	}
+	sessionMU.Unlock()
+
+	counts := make(map[string]int, len(sessions))
+	errs := map[string]error{}
+	for i := range sessions {
+		server := sessions[i].params.server
+		if _, ok := counts[server]; ok {
+			continue
+		}
+		count, err := sessions[i].ActiveSessionCount(ctx)
+		if err != nil {
+			errs[server] = err
+			continue
+		}
+		counts[server] = count
+		delete(errs, server)
+	}
+	servers := make([]string, 0, len(errs))
+	for server := range errs {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	aggregate := make([]error, 0, len(servers))
+	for _, server := range servers {
+		aggregate = append(aggregate, errs[server])
+	}
+	return counts, kerrors.NewAggregate(aggregate)
+}
+
+// RefreshThumbprint replaces the thumbprint against which the vSphere
+// server's certificate is verified, such as after a keepalive or health
+// check fails with ErrThumbprintChanged. The thumbprints of other hosts
+// continue to be accepted. As the thumbprints are part of the key under
+// which the session is cached, the session is cached anew under the key for
+// its refreshed parameters. It is an error to refresh the thumbprint of a
+// session that does not verify the server by thumbprint.
+func (s *Session) RefreshThumbprint(thumbprint string) error {
+	if s.verifier == nil {
+		return errors.Errorf("vSphere session for %q does not verify the server by thumbprint", s.params.server)
+	}
+	if thumbprint == "" {
+		return errors.New("thumbprint is required")
+	}
+
+	// The server's thumbprint is the one set with WithThumbprint, or the one
+	// set for its host with WithThumbprints.
+	host := s.URL().Host
+	params := s.params
+	_, pinned := params.thumbprints[host]
+	if pinned {
+		thumbprints := make(map[string]string, len(params.thumbprints))
+		for h, t := range params.thumbprints {
+			thumbprints[h] = t
+		}
+		thumbprints[host] = thumbprint
+		params.thumbprints = thumbprints
+	}
+	if params.thumbprint != "" || !pinned {
+		params.thumbprint = thumbprint
+	}
+
+	s.verifier.set(params.allowedThumbprints()...)
+	s.Client.Client.Client.SetThumbprint(host, thumbprint)
+
+	sessionKey := params.key()
+	sessionMU.Lock()
+	if cached, ok := sessionCache[s.sessionKey]; ok && cached.Client == s.Client {
+		cached.params, cached.sessionKey = params, sessionKey
+		sessionCache[sessionKey] = cached
+		sessionLastUsed[sessionKey] = sessionLastUsed[s.sessionKey]
+		delete(sessionCache, s.sessionKey)
+		delete(sessionLastUsed, s.sessionKey)
+	}
+	sessionMU.Unlock()
+	s.params, s.sessionKey = params, sessionKey
+	return nil
+}
+
+// WithContext returns a shallow copy of the session whose lookups, such as
+// FinderForDatacenter and the Find methods, are also cancelled when ctx is
+// done. This allows a cached session to honor the deadline of a single
+// reconcile. The copy shares the session's client and caches.
+func (s *Session) WithContext(ctx context.Context) *Session {
+	session := *s
+	session.ctx = ctx
+	return &session
+}
+
+// callContext returns a context for a call made with ctx that is also
+// cancelled when the context set by WithContext is done. The returned
+// cancel function must be called when the call returns.
+func (s *Session) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.ctx == nil {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	if s.ctx.Err() != nil {
+		cancel()
+		return ctx, cancel
+	}
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// FinderForDatacenter returns a Finder scoped to the given datacenter that
+// shares the session's authenticated client. The default datacenter is
+// used if datacenter is empty. Each datacenter is resolved once and cached
+// until the session is evicted or closed.
+func (s *Session) FinderForDatacenter(ctx context.Context, datacenter string) (*find.Finder, error) {
+	if s.Client == nil {
+		return nil, errors.New("vSphere client is not initialized")
+	}
+	ctx, cancel := s.callContext(ctx)
+	defer cancel()
+	finder := find.NewFinder(s.Client.Client, false)
+	dc, ok := s.datacenters.get(datacenter)
+	if !ok {
+		var err error
+		if dc, err = finder.DatacenterOrDefault(ctx, datacenter); err != nil {
+			return nil, errors.Wrapf(err, "unable to find datacenter %q", datacenter)
+		}
+		s.datacenters.set(datacenter, dc)
+	}
+	finder.SetDatacenter(dc)
+	return finder, nil
+}
+
+// EnsureFolder returns the VM folder at the given inventory path, creating
+// it and any missing parent folders. A relative path is relative to the
+// VM folder of the session's datacenter. Folders are only created within
+// that VM folder; a missing folder elsewhere is an error.
+func (s *Session) EnsureFolder(ctx context.Context, folderPath string) (*object.Folder, error) {
+	if s.Client == nil || s.Finder == nil {
+		return nil, errors.New("vSphere client is not initialized")
+	}
+	ctx, cancel := s.callContext(ctx)
+	defer cancel()
+
+	root, err := s.Finder.DefaultFolder(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to find the datacenter's VM folder")
+	}
+	if !path.IsAbs(folderPath) {
+		folderPath = path.Join(root.InventoryPath, folderPath)
+	}
+	folderPath = path.Clean(folderPath)
+
+	// Find the closest existing ancestor, remembering the names of the
+	// folders to create beneath it.
+	var missing []string
+	parent := root
+	for p := folderPath; p != root.InventoryPath; p = path.Dir(p) {
+		folder, err := s.Finder.Folder(ctx, p)
+		if err == nil {
+			parent = folder
+			break
+		}
+		if _, ok := err.(*find.NotFoundError); !ok {
+			return nil, errors.Wrapf(err, "unable to find folder %q", p)
+		}
+		if !strings.HasPrefix(p, root.InventoryPath+"/") {
+			return nil, errors.Wrapf(err, "unable to create folder %q outside %q", folderPath, root.InventoryPath)
+		}
+		missing = append([]string{path.Base(p)}, missing...)
+	}
+
+	for _, name := range missing {
+		childPath := path.Join(parent.InventoryPath, name)
+		child, err := parent.CreateFolder(ctx, name)
+		if err != nil {
+			// The folder may have been created concurrently.
+			if !isDuplicateName(err) {
+				return nil, errors.Wrapf(err, "unable to create folder %q", childPath)
+			}
+			if child, err = s.Finder.Folder(ctx, childPath); err != nil {
+				return nil, errors.Wrapf(err, "unable to find folder %q", childPath)
+			}
+		}
+		child.InventoryPath = childPath
+		parent = child
+	}
+	return parent, nil
+}
+
+// isDuplicateName returns true if err is a vSphere fault reporting that an
+// object with the same name already exists.
+func isDuplicateName(err error) bool {
+	if !soap.IsSoapFault(err) {
+		return false
+	}
+	_, ok := soap.ToSoapFault(err).VimFault().(types.DuplicateName)
+	return ok
+}
+
+// isSessionExpired returns true if err is a vSphere fault reporting that the
+// session is not authenticated or its login is invalid.
+func isSessionExpired(err error) bool {
+	err = errors.Cause(err)
+	var fault types.AnyType
+	switch {
+	case soap.IsSoapFault(err):
+		fault = soap.ToSoapFault(err).VimFault()
+	case soap.IsVimFault(err):
+		fault = soap.ToVimFault(err)
+	default:
+		return false
+	}
+	switch fault.(type) {
+	case types.NotAuthenticated, *types.NotAuthenticated, types.InvalidLogin, *types.InvalidLogin:
+		return true
+	}
+	return false
+}
+
+// AboutInfo returns information about the vSphere server, such as its
+// product name, version, and API version. It is read from the service
+// content retrieved when the session was created, so it does not make a
+// request to the server.
+func (s *Session) AboutInfo() types.AboutInfo {
+	if s.Client == nil || s.Client.Client == nil {
+		return types.AboutInfo{}
+	}
+	return s.Client.ServiceContent.About
+}
+
+// SupportsAPIVersion returns true if the vSphere server's API version is at
+// least min, such as "6.7.3". Versions are compared component by
+// component. False is returned if either version cannot be parsed.
+func (s *Session) SupportsAPIVersion(min string) bool {
+	minVersion, err := version.ParseGeneric(min)
+	if err != nil {
+		return false
+	}
+	apiVersion, err := version.ParseGeneric(s.AboutInfo().ApiVersion)
+	if err != nil {
+		return false
+	}
+	return apiVersion.AtLeast(minVersion)
+}
+
+// FindObjectByPath returns the object of the given kind, one of the
+// ObjectKind constants, with the given name or inventory path. Names and
+// relative paths are resolved in the session's datacenter. An
+// *ObjectNotFoundError is returned if there is no such object.
+func (s *Session) FindObjectByPath(ctx context.Context, kind, path string) (object.Reference, error) {
+	if s.Client == nil || s.Finder == nil {
+		return nil, errors.New("vSphere client is not initialized")
+	}
+	if path == "" {
+		return nil, errors.Errorf("%s path is empty", kind)
+	}
+	ctx, cancel := s.callContext(ctx)
+	defer cancel()
+
+	var (
+		ref object.Reference
+		err error
+	)
+	switch kind {
+	case ObjectKindDatastore:
+		ref, err = s.Finder.Datastore(ctx, path)
+	case ObjectKindFolder:
+		ref, err = s.Finder.Folder(ctx, path)
+	case ObjectKindResourcePool:
+		ref, err = s.Finder.ResourcePool(ctx, path)
+	case ObjectKindNetwork:
+		ref, err = s.Finder.Network(ctx, path)
+	default:
+		return nil, errors.Errorf("unsupported object kind %q", kind)
+	}
+	if err != nil {
+		if _, ok := err.(*find.NotFoundError); ok {
+			return nil, &ObjectNotFoundError{Kind: kind, Path: path, Err: err}
+		}
+		return nil, errors.Wrapf(err, "error finding %s %q", kind, path)
+	}
+	return ref, nil
+}
+
+// TagManager returns a manager for the vSphere tags and categories
+// available to the session. The session's REST client is logged in with
+// the session's credentials the first time it is needed.
+func (s *Session) TagManager(ctx context.Context) (*tags.Manager, error) {
+	if s.Client == nil || s.rest == nil {
+		return nil, errors.New("vSphere client is not initialized")
+	}
+
+	s.rest.mu.Lock()
+	defer s.rest.mu.Unlock()
+
+	if s.rest.client == nil {
+		restClient := rest.NewClient(s.Client.Client)
+		if err := setProxy(restClient.Client, s.params.proxyURL); err != nil {
+			return nil, err
+		}
+		params, err := s.params.provideCredentials(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if params.tokenSigner != nil {
+			err = restClient.LoginByToken(restClient.WithSigner(ctx, params.tokenSigner))
+		} else {
+			err = restClient.Login(ctx, params.userinfo)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "error logging into vSphere REST API %q", s.params.server)
+		}
+		s.rest.client = restClient
+	}
+
+	return tags.NewManager(s.rest.client), nil
 }
 
 // FindByBIOSUUID finds an object by its BIOS UUID.
@@ -110,13 +1301,76 @@ func (s *Session) FindByInstanceUUID(ctx context.Context, uuid string) (object.R
 	return s.findByUUID(ctx, uuid, true)
 }
 
+// FindByProviderID finds a VM by the UUID encoded in a provider ID. The
+// VM is found by its instance UUID if the provider ID was built from one,
+// otherwise by its BIOS UUID.
+func (s *Session) FindByProviderID(ctx context.Context, providerID string) (object.Reference, error) {
+	uuid, instanceUUID, err := util.ParseProviderIDKind(&providerID)
+	if err != nil {
+		return nil, err
+	}
+	return s.findByUUID(ctx, uuid, instanceUUID)
+}
+
+// FindAllByInstanceUUID finds VMs by their instance UUIDs in a single
+// property collector retrieval rather than one search per UUID. The result
+// is keyed by the given UUIDs. UUIDs that do not match a VM map to nil.
+func (s *Session) FindAllByInstanceUUID(ctx context.Context, uuids []string) (map[string]object.Reference, error) {
+	if s.Client == nil {
+		return nil, errors.New("vSphere client is not initialized")
+	}
+
+	ctx, cancel := s.callContext(ctx)
+	defer cancel()
+	root := s.Client.ServiceContent.RootFolder
+	if s.datacenter != nil {
+		root = s.datacenter.Reference()
+	}
+	v, err := view.NewManager(s.Client.Client).CreateContainerView(ctx, root, []string{"VirtualMachine"}, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating container view")
+	}
+	defer func() {
+		_ = v.Destroy(ctx)
+	}()
+
+	var vms []mo.VirtualMachine
+	if err := v.Retrieve(ctx, []string{"VirtualMachine"}, []string{"config.instanceUuid"}, &vms); err != nil {
+		return nil, errors.Wrap(err, "error retrieving instance uuids")
+	}
+	found := make(map[string]types.ManagedObjectReference, len(vms))
+	for _, vm := range vms {
+		if vm.Config == nil || vm.Config.InstanceUuid == "" {
+			continue
+		}
+		found[strings.ToLower(vm.Config.InstanceUuid)] = vm.Reference()
+	}
+
+	refs := make(map[string]object.Reference, len(uuids))
+	for _, uuid := range uuids {
+		if ref, ok := found[strings.ToLower(uuid)]; ok {
+			refs[uuid] = object.NewVirtualMachine(s.Client.Client, ref)
+		} else {
+			refs[uuid] = nil
+		}
+	}
+	return refs, nil
+}
+
 func (s *Session) findByUUID(ctx context.Context, uuid string, findByInstanceUUID bool) (object.Reference, error) {
 	if s.Client == nil {
 		return nil, errors.New("vSphere client is not initialized")
 	}
+	ctx, cancel := s.callContext(ctx)
+	defer cancel()
 	si := object.NewSearchIndex(s.Client.Client)
 	ref, err := si.FindByUuid(ctx, s.datacenter, uuid, true, &findByInstanceUUID)
 	if err != nil {
+		if isSessionExpired(err) {
+			clearCache(s.sessionKey, s.Client)
+			s.params.log().V(2).Info("evicted expired vSphere client session", "error", err.Error())
+			return nil, errors.Wrapf(ErrSessionExpired, "error finding object by uuid %q", uuid)
+		}
 		return nil, errors.Wrapf(err, "error finding object by uuid %q", uuid)
 	}
 	return ref, nil
@@ -0,0 +1,1685 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/simulator/vpx"
+	"github.com/vmware/govmomi/sts"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	_ "github.com/vmware/govmomi/vapi/simulator"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/util"
+)
+
+func newSimulator(t *testing.T) (*simulator.Model, *simulator.Server) {
+	model := simulator.VPX()
+	model.Host = 0
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+	model.Service.RegisterEndpoints = true
+	return model, model.Service.NewServer()
+}
+
+func TestGetOrCreatePasswordChange(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	username := server.URL.User.Username()
+	params := func(password string) *Params {
+		return NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(username, password)
+	}
+
+	first, err := GetOrCreate(ctx, params("password-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := GetOrCreate(ctx, params("password-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Client != cached.Client {
+		t.Error("expected the cached session to be reused for the same credentials")
+	}
+
+	second, err := GetOrCreate(ctx, params("password-2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Client == second.Client {
+		t.Error("expected a new session to be created after the password changed")
+	}
+}
+
+func TestClose(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)
+
+	s, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("expected a second Close to succeed, got %v", err)
+	}
+	if active, _ := s.SessionManager.SessionIsActive(ctx); active {
+		t.Error("expected the session to be logged out")
+	}
+
+	next, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Client == s.Client {
+		t.Error("expected a closed session to be removed from the cache")
+	}
+}
+
+func TestEvict(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)
+
+	s, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Evict(params)
+
+	next, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Client == s.Client {
+		t.Error("expected an evicted session to be removed from the cache")
+	}
+}
+
+func TestGetOrCreateConcurrentLogin(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)
+
+	const callers = 50
+	sessions := make([]*Session, callers)
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			sessions[i], errs[i] = GetOrCreate(ctx, params)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range errs {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if sessions[i].Client != sessions[0].Client {
+			t.Errorf("expected caller %d to share the session of caller 0", i)
+		}
+	}
+
+	var sm mo.SessionManager
+	if err := sessions[0].RetrieveOne(ctx, *sessions[0].ServiceContent.SessionManager, []string{"sessionList"}, &sm); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(sm.SessionList); n != 1 {
+		t.Errorf("expected exactly one login, got %d", n)
+	}
+}
+
+func TestGetOrCreateCancelledCallerSharesLogin(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	// Hold the login until the first caller has given up.
+	var logins int32
+	loginStarted, release := make(chan struct{}), make(chan struct{})
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: server.URL.Scheme, Host: server.URL.Host})
+	proxy.Transport = &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // nolint:gosec
+	}
+	gated := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if bytes.Contains(body, []byte("<Login ")) {
+			if atomic.AddInt32(&logins, 1) == 1 {
+				close(loginStarted)
+			}
+			<-release
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer gated.Close()
+
+	pass, _ := server.URL.User.Password()
+	params := NewParams().WithServer(gated.URL).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)
+	defer Evict(params)
+
+	firstCtx, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := GetOrCreate(firstCtx, params)
+		firstErr <- err
+	}()
+	<-loginStarted
+
+	type result struct {
+		session *Session
+		err     error
+	}
+	second := make(chan result, 1)
+	go func() {
+		s, err := GetOrCreate(context.Background(), params)
+		second <- result{s, err}
+	}()
+
+	cancel()
+	if err := <-firstErr; err != context.Canceled {
+		t.Errorf("expected %v for the cancelled caller, got %v", context.Canceled, err)
+	}
+
+	close(release)
+	res := <-second
+	if res.err != nil {
+		t.Fatalf("expected the second caller to get a session, got %v", res.err)
+	}
+	if res.session == nil {
+		t.Fatal("expected a session")
+	}
+	if n := atomic.LoadInt32(&logins); n != 1 {
+		t.Errorf("expected exactly one login, got %d", n)
+	}
+}
+
+func TestGetOrCreateCertificateVerification(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	cert := server.Certificate()
+	thumbprint := soap.ThumbprintSHA1(cert)
+	caCerts := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+
+	testCases := []struct {
+		name        string
+		thumbprint  string
+		caCerts     []byte
+		insecure    bool
+		expectError bool
+	}{
+		{
+			// The simulator's self-signed certificate is not trusted by
+			// the system roots.
+			name:        "system roots verify the server by default",
+			expectError: true,
+		},
+		{
+			name:     "explicitly insecure",
+			insecure: true,
+		},
+		{
+			name:       "thumbprint matches",
+			thumbprint: thumbprint,
+		},
+		{
+			name:        "insecure does not skip a pinned thumbprint",
+			thumbprint:  "00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00",
+			insecure:    true,
+			expectError: true,
+		},
+		{
+			name:        "thumbprint does not match",
+			thumbprint:  "00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00",
+			expectError: true,
+		},
+		{
+			name:    "CA certs verify the server",
+			caCerts: caCerts,
+		},
+		{
+			name:        "CA certs take precedence over a matching thumbprint",
+			thumbprint:  thumbprint,
+			caCerts:     []byte("invalid"),
+			expectError: true,
+		},
+		{
+			name:       "CA certs take precedence over a mismatched thumbprint",
+			thumbprint: "00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00",
+			caCerts:    caCerts,
+		},
+	}
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := NewParams().
+				WithServer(server.URL.Host).
+				WithInsecure(tc.insecure).
+				WithUserInfo(server.URL.User.Username(), pass).
+				WithThumbprint(tc.thumbprint).
+				WithCACerts(tc.caCerts)
+			_, err := GetOrCreate(ctx, params)
+			if tc.expectError && err == nil {
+				t.Error("expected an error")
+			}
+			if !tc.expectError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetOrCreateThumbprints(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	thumbprint := soap.ThumbprintSHA1(server.Certificate())
+	const (
+		otherHost       = "vcenter-2.local:443"
+		otherThumbprint = "00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00"
+	)
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	params := NewParams().
+		WithServer(server.URL.Host).
+		WithInsecure(true).
+		WithUserInfo(server.URL.User.Username(), pass).
+		WithThumbprints(map[string]string{
+			server.URL.Host: thumbprint,
+			otherHost:       otherThumbprint,
+		})
+	s, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	soapClient := s.Client.Client.Client
+	if actual := soapClient.Thumbprint(server.URL.Host); actual != thumbprint {
+		t.Errorf("expected thumbprint %q for %q, got %q", thumbprint, server.URL.Host, actual)
+	}
+	if actual := soapClient.Thumbprint(otherHost); actual != otherThumbprint {
+		t.Errorf("expected thumbprint %q for %q, got %q", otherThumbprint, otherHost, actual)
+	}
+
+	// A server behind a load balanced address may present the certificate
+	// of any of the hosts.
+	params = NewParams().
+		WithServer(server.URL.Host).
+		WithInsecure(true).
+		WithUserInfo(server.URL.User.Username(), pass).
+		WithThumbprint(otherThumbprint).
+		WithThumbprints(map[string]string{otherHost: thumbprint})
+	if _, err := GetOrCreate(ctx, params); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	params = NewParams().
+		WithServer(server.URL.Host).
+		WithInsecure(true).
+		WithUserInfo(server.URL.User.Username(), pass).
+		WithThumbprints(map[string]string{server.URL.Host: otherThumbprint})
+	if _, err := GetOrCreate(ctx, params); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestRefreshThumbprint(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	thumbprint := soap.ThumbprintSHA1(server.Certificate())
+	const staleThumbprint = "00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00"
+	const otherHost = "vcenter.example.com:443"
+	const otherThumbprint = "11:11:11:11:11:11:11:11:11:11:11:11:11:11:11:11:11:11:11:11"
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	params := NewParams().
+		WithServer(server.URL.Host).
+		WithInsecure(true).
+		WithUserInfo(server.URL.User.Username(), pass).
+		WithThumbprints(map[string]string{server.URL.Host: thumbprint, otherHost: otherThumbprint})
+	s, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate the server's certificate being rotated by pinning a
+	// thumbprint it does not match, and force a new TLS handshake.
+	soapClient := s.Client.Client.Client
+	if err := s.RefreshThumbprint(staleThumbprint); err != nil {
+		t.Fatal(err)
+	}
+	soapClient.DefaultTransport().CloseIdleConnections()
+
+	if err := s.Healthy(ctx); !errors.Is(err, ErrThumbprintChanged) {
+		t.Fatalf("expected %v, got %v", ErrThumbprintChanged, err)
+	}
+	if err := keepAliveHandler(s.Client, &s.params)(soapClient); !errors.Is(err, ErrThumbprintChanged) {
+		t.Fatalf("expected the keepalive to fail with %v, got %v", ErrThumbprintChanged, err)
+	}
+	sessionMU.Lock()
+	_, cached := sessionCache[s.sessionKey]
+	sessionMU.Unlock()
+	if !cached {
+		t.Error("expected the session to remain cached after the thumbprint changed")
+	}
+
+	if err := s.RefreshThumbprint(thumbprint); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Healthy(ctx); err != nil {
+		t.Errorf("expected the session to be healthy after refreshing the thumbprint, got %v", err)
+	}
+	if actual := soapClient.Thumbprint(server.URL.Host); actual != thumbprint {
+		t.Errorf("expected thumbprint %q to be registered, got %q", thumbprint, actual)
+	}
+
+	// Only the server's thumbprint is replaced.
+	if actual := s.verifier.thumbprints; len(actual) != 2 {
+		t.Errorf("expected both thumbprints to be allowed, got %v", actual)
+	}
+	if actual := soapClient.Thumbprint(otherHost); actual != otherThumbprint {
+		t.Errorf("expected thumbprint %q to be registered, got %q", otherThumbprint, actual)
+	}
+
+	// The session is cached under the key for its refreshed parameters.
+	refreshed, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.Client != s.Client {
+		t.Error("expected the refreshed session to be cached under its new key")
+	}
+
+	if err := s.RefreshThumbprint(""); err == nil {
+		t.Error("expected an error refreshing the thumbprint to an empty one")
+	}
+
+	insecure, err := GetOrCreate(ctx, NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := insecure.RefreshThumbprint(thumbprint); err == nil {
+		t.Error("expected an error refreshing the thumbprint of a session that does not verify one")
+	}
+}
+
+func TestGetOrCreateProxy(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	// The proxy tunnels CONNECT requests to their destination and counts
+	// how many tunnels it opened.
+	var tunnels int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "expected CONNECT", http.StatusMethodNotAllowed)
+			return
+		}
+		dst, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		src, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			dst.Close()
+			return
+		}
+		atomic.AddInt32(&tunnels, 1)
+		_, _ = src.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			_, _ = io.Copy(dst, src)
+			dst.Close()
+		}()
+		go func() {
+			_, _ = io.Copy(src, dst)
+			src.Close()
+		}()
+	}))
+	defer proxy.Close()
+
+	pass, _ := server.URL.User.Password()
+	params := NewParams().
+		WithServer(server.URL.Host).
+		WithInsecure(true).
+		WithUserInfo(server.URL.User.Username(), pass).
+		WithProxy(proxy.URL)
+	if _, err := GetOrCreate(context.Background(), params); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&tunnels) == 0 {
+		t.Error("expected the session to connect through the proxy")
+	}
+}
+
+// expiredSessionRoundTripper fails every request with a NotAuthenticated
+// fault, as vCenter does once a session has expired.
+type expiredSessionRoundTripper struct{}
+
+func (expiredSessionRoundTripper) RoundTrip(_ context.Context, _, _ soap.HasFault) error {
+	return soap.WrapVimFault(&types.NotAuthenticated{})
+}
+
+// faultRoundTripper fails every request with the given fault.
+type faultRoundTripper struct {
+	fault types.BaseMethodFault
+}
+
+func (f faultRoundTripper) RoundTrip(_ context.Context, _, _ soap.HasFault) error {
+	return soap.WrapVimFault(f.fault)
+}
+
+func TestFindByUUIDSessionExpired(t *testing.T) {
+	testCases := []struct {
+		name  string
+		fault types.BaseMethodFault
+	}{
+		{name: "not authenticated", fault: &types.NotAuthenticated{}},
+		{name: "invalid login", fault: &types.InvalidLogin{}},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			model, server := newSimulator(t)
+			defer model.Remove()
+			defer server.Close()
+
+			ctx := context.Background()
+			pass, _ := server.URL.User.Password()
+			params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)
+			s, err := GetOrCreate(ctx, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s.Client.Client.RoundTripper = faultRoundTripper{fault: tc.fault}
+			_, err = s.FindByInstanceUUID(ctx, "5009a1d4-7a4b-4b48-8a8b-1b9b0b1d2e3f")
+			if !errors.Is(err, ErrSessionExpired) {
+				t.Fatalf("expected ErrSessionExpired, got %v", err)
+			}
+			sessionMU.Lock()
+			_, cached := sessionCache[params.key()]
+			sessionMU.Unlock()
+			if cached {
+				t.Error("expected the expired session to be evicted")
+			}
+		})
+	}
+}
+
+func TestFindByUUIDOtherFault(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)
+	s, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Evict(params)
+
+	s.Client.Client.RoundTripper = faultRoundTripper{fault: &types.InvalidArgument{}}
+	_, err = s.FindByBIOSUUID(ctx, "5009a1d4-7a4b-4b48-8a8b-1b9b0b1d2e3f")
+	if err == nil || errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("expected a fault other than ErrSessionExpired, got %v", err)
+	}
+	sessionMU.Lock()
+	_, cached := sessionCache[params.key()]
+	sessionMU.Unlock()
+	if !cached {
+		t.Error("expected the session to stay cached")
+	}
+}
+
+func TestGetOrCreateMaxConnections(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	pass, _ := server.URL.User.Password()
+	params := NewParams().
+		WithServer(server.URL.Host).
+		WithInsecure(true).
+		WithUserInfo(server.URL.User.Username(), pass).
+		WithMaxConnections(4)
+
+	s, err := GetOrCreate(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := s.Client.Client.Client.DefaultTransport()
+	if transport.MaxConnsPerHost != 4 {
+		t.Errorf("expected MaxConnsPerHost to be 4, got %d", transport.MaxConnsPerHost)
+	}
+	if transport.MaxIdleConnsPerHost != 4 {
+		t.Errorf("expected MaxIdleConnsPerHost to be 4, got %d", transport.MaxIdleConnsPerHost)
+	}
+}
+
+func TestGetOrCreateUserAgent(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	newParams := func() *Params {
+		return NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)
+	}
+
+	testCases := []struct {
+		name              string
+		params            *Params
+		expectedUserAgent string
+	}{
+		{
+			name:              "default",
+			params:            newParams(),
+			expectedUserAgent: "infrastructure.cluster.x-k8s.io/v1alpha3",
+		},
+		{
+			name:              "custom",
+			params:            newParams().WithUserAgent("capv-controller-a"),
+			expectedUserAgent: "capv-controller-a",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := GetOrCreate(ctx, tc.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := s.Client.Client.Client.UserAgent; actual != tc.expectedUserAgent {
+				t.Errorf("expected the client's user agent to be %q, got %q", tc.expectedUserAgent, actual)
+			}
+			userSession, err := s.SessionManager.UserSession(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if userSession == nil || userSession.UserAgent != tc.expectedUserAgent {
+				t.Errorf("expected the server to record user agent %q, got %v", tc.expectedUserAgent, userSession)
+			}
+		})
+	}
+}
+
+func TestKeepAliveHandlerRelogin(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)
+
+	client, _, err := newClient(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := client.SessionManager.UserSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop the session cookie so the server treats the next login as a new
+	// session rather than rejecting it as a duplicate.
+	client.Client.Client.Jar, _ = cookiejar.New(nil)
+
+	if err := keepAliveHandler(client, params)(expiredSessionRoundTripper{}); err != nil {
+		t.Fatalf("expected the keepalive to log back in, got %v", err)
+	}
+	after, err := client.SessionManager.UserSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after == nil || after.Key == before.Key {
+		t.Error("expected a new login after the keepalive failed")
+	}
+}
+
+func TestCredentialProviderRelogin(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	var calls int
+	provider := func(context.Context) (string, string, error) {
+		calls++
+		return fmt.Sprintf("user-%d", calls), fmt.Sprintf("password-%d", calls), nil
+	}
+	params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithCredentialProvider(provider)
+
+	s, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Evict(&s.params)
+	first, err := s.SessionManager.UserSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == nil || first.UserName != "user-1" {
+		t.Fatalf("expected the first login to use the first credentials, got %+v", first)
+	}
+
+	// Drop the session cookie so the server treats the next login as a new
+	// session rather than rejecting it as a duplicate.
+	s.Client.Client.Jar, _ = cookiejar.New(nil)
+
+	if err := keepAliveHandler(s.Client, &s.params)(expiredSessionRoundTripper{}); err != nil {
+		t.Fatalf("expected the keepalive to log back in, got %v", err)
+	}
+	second, err := s.SessionManager.UserSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == nil || second.UserName != "user-2" {
+		t.Errorf("expected the second login to use the rotated credentials, got %+v", second)
+	}
+}
+
+func TestEvictCredentialProvider(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	var logins int
+	provider := func(context.Context) (string, string, error) {
+		logins++
+		return fmt.Sprintf("user-%d", logins), fmt.Sprintf("password-%d", logins), nil
+	}
+	params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithCredentialProvider(provider)
+
+	s, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Client != s.Client || logins != 1 {
+		t.Fatalf("expected the session to be cached after one login, got %d login(s)", logins)
+	}
+
+	// The parameters passed to Evict have not been through the provider.
+	Evict(params)
+
+	next, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Evict(params)
+	if next.Client == s.Client || logins != 2 {
+		t.Errorf("expected an evicted session to log in again, got %d login(s)", logins)
+	}
+}
+
+func TestTagManager(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)
+
+	s, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagManager, err := s.TagManager(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tagManager.CreateCategory(ctx, &tags.Category{Name: "zone"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A copy of the session shares the REST client that is already logged in.
+	cached, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagManager, err = cached.TagManager(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	categories, err := tagManager.GetCategories(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(categories) != 1 || categories[0].Name != "zone" {
+		t.Errorf("expected the category %q, got %v", "zone", categories)
+	}
+}
+
+func TestFinderForDatacenter(t *testing.T) {
+	model := simulator.VPX()
+	model.Datacenter = 2
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	defer model.Remove()
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	params := NewParams().
+		WithServer(server.URL.Host).
+		WithInsecure(true).
+		WithDatacenter("DC0").
+		WithUserInfo(server.URL.User.Username(), pass)
+
+	s, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, datacenter := range []string{"DC0", "DC1"} {
+		finder, err := s.FinderForDatacenter(ctx, datacenter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vms, err := finder.VirtualMachineList(ctx, "*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(vms) == 0 {
+			t.Errorf("expected to find VMs in datacenter %q", datacenter)
+		}
+		for _, vm := range vms {
+			if !strings.HasPrefix(vm.InventoryPath, "/"+datacenter+"/") {
+				t.Errorf("expected VM %q to be in datacenter %q", vm.InventoryPath, datacenter)
+			}
+		}
+	}
+
+	if _, err := s.FinderForDatacenter(ctx, "DC2"); err == nil {
+		t.Error("expected an error for a datacenter that does not exist")
+	}
+}
+
+// newFlakyLoginServer returns a server that proxies requests to the given
+// simulator but fails the first failures login requests.
+func newFlakyLoginServer(server *simulator.Server, failures int32) (*httptest.Server, *int32) {
+	var logins int32
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: server.URL.Scheme, Host: server.URL.Host})
+	proxy.Transport = &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // nolint:gosec
+	}
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if bytes.Contains(body, []byte("<Login ")) {
+			if atomic.AddInt32(&logins, 1) <= failures {
+				http.Error(w, "vCenter is unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		proxy.ServeHTTP(w, r)
+	})), &logins
+}
+
+func TestGetOrCreateLoginRetry(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	flaky, logins := newFlakyLoginServer(server, 2)
+	defer flaky.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	newParams := func() *Params {
+		return NewParams().
+			WithServer(flaky.URL).
+			WithInsecure(true).
+			WithUserInfo(server.URL.User.Username(), pass).
+			WithLoginTimeout(time.Second)
+	}
+
+	_, err := GetOrCreate(ctx, newParams().WithLoginRetry(2, time.Millisecond))
+	if err == nil || !strings.Contains(err.Error(), "after 2 attempt(s)") {
+		t.Fatalf("expected an error after 2 attempts, got %v", err)
+	}
+
+	atomic.StoreInt32(logins, 0)
+	if _, err := GetOrCreate(ctx, newParams().WithLoginRetry(3, time.Millisecond)); err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+	if n := atomic.LoadInt32(logins); n != 3 {
+		t.Errorf("expected 3 login attempts, got %d", n)
+	}
+}
+
+// newBearerToken returns a minimal SAML bearer token for the given
+// principal, as an STS would issue.
+func newBearerToken(principal string) *sts.Signer {
+	return &sts.Signer{
+		Token: `<saml2:Assertion xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion" ID="_` + principal + `">` +
+			`<saml2:Subject><saml2:NameID>` + principal + `</saml2:NameID></saml2:Subject>` +
+			`</saml2:Assertion>`,
+	}
+}
+
+func TestGetOrCreateTokenSigner(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	sessions := map[string]*Session{}
+	for _, principal := range []string{"alice@vsphere.local", "bob@vsphere.local"} {
+		params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithTokenSigner(newBearerToken(principal))
+		s, err := GetOrCreate(ctx, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		userSession, err := s.SessionManager.UserSession(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userSession == nil || userSession.UserName != principal {
+			t.Errorf("expected a session for %q, got %v", principal, userSession)
+		}
+		sessions[principal] = s
+	}
+
+	if sessions["alice@vsphere.local"].Client == sessions["bob@vsphere.local"].Client {
+		t.Error("expected distinct principals to have distinct sessions")
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+
+	ctx := context.Background()
+	username := server.URL.User.Username()
+	pass, _ := server.URL.User.Password()
+	params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(username, pass)
+
+	s, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Healthy(ctx); err != nil {
+		t.Errorf("expected the session to be healthy, got %v", err)
+	}
+	label := username + "@" + server.URL.Host
+	if err, ok := CheckAll(ctx)[label]; !ok || err != nil {
+		t.Errorf("expected %q to be reported healthy, got %v", label, err)
+	}
+
+	server.Close()
+	if err := s.Healthy(ctx); err == nil {
+		t.Error("expected the session to be unhealthy after the server stopped")
+	}
+	if err, ok := CheckAll(ctx)[label]; !ok || err == nil {
+		t.Errorf("expected %q to be reported unhealthy", label)
+	}
+}
+
+func TestActiveSessionCount(t *testing.T) {
+	// Other tests leave sessions to stopped servers in the cache.
+	sessionMU.Lock()
+	sessionCache = map[string]Session{}
+	sessionMU.Unlock()
+
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	username := server.URL.User.Username()
+	var sessions []*Session
+	for _, password := range []string{"password-1", "password-2"} {
+		s, err := GetOrCreate(ctx, NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(username, password))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions = append(sessions, s)
+	}
+
+	count, err := sessions[0].ActiveSessionCount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 active sessions, got %d", count)
+	}
+
+	counts, err := ActiveSessionCounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 1 || counts[server.URL.Host] != 2 {
+		t.Errorf("expected 2 active sessions on %q, got %v", server.URL.Host, counts)
+	}
+
+	if err := sessions[1].Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := sessions[0].ActiveSessionCount(ctx); err != nil || count != 1 {
+		t.Errorf("expected 1 active session after closing one, got %d (%v)", count, err)
+	}
+}
+
+func TestActiveSessionCountsErrors(t *testing.T) {
+	// Other tests leave sessions to stopped servers in the cache.
+	sessionMU.Lock()
+	sessionCache = map[string]Session{}
+	sessionMU.Unlock()
+
+	ctx := context.Background()
+	var servers []string
+	for i := 0; i < 2; i++ {
+		model, server := newSimulator(t)
+		defer model.Remove()
+
+		pass, _ := server.URL.User.Password()
+		if _, err := GetOrCreate(ctx, NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)); err != nil {
+			t.Fatal(err)
+		}
+		server.Close()
+		servers = append(servers, server.URL.Host)
+	}
+	sort.Strings(servers)
+
+	// The errors for every failed server are reported in the same order
+	// however the cache is ranged over.
+	for i := 0; i < 5; i++ {
+		_, err := ActiveSessionCounts(ctx)
+		aggregate, ok := err.(kerrors.Aggregate)
+		if !ok || len(aggregate.Errors()) != len(servers) {
+			t.Fatalf("expected an error for each of %v, got %v", servers, err)
+		}
+		for j, server := range servers {
+			if msg := aggregate.Errors()[j].Error(); !strings.Contains(msg, server) {
+				t.Errorf("expected error %d to be for %q, got %q", j, server, msg)
+			}
+		}
+	}
+}
+
+func TestGetOrCreateCancelledContext(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	flaky, logins := newFlakyLoginServer(server, 0)
+	defer flaky.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	pass, _ := server.URL.User.Password()
+	params := NewParams().WithServer(flaky.URL).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)
+	if _, err := GetOrCreate(ctx, params); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	if n := atomic.LoadInt32(logins); n != 0 {
+		t.Errorf("expected no login attempts, got %d", n)
+	}
+}
+
+func TestGetOrCreateInvalidParams(t *testing.T) {
+	testCases := []struct {
+		name          string
+		params        *Params
+		expectedField string
+	}{
+		{
+			name:          "missing server",
+			params:        NewParams().WithUserInfo("user", "pass"),
+			expectedField: "server",
+		},
+		{
+			name:          "missing userinfo",
+			params:        NewParams().WithServer("vcenter.local"),
+			expectedField: "userinfo",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := GetOrCreate(context.Background(), tc.params)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tc.expectedField) {
+				t.Errorf("expected the error to name %q, got %q", tc.expectedField, err)
+			}
+		})
+	}
+}
+
+// fakeManager is a Manager that returns a fixed session without connecting
+// to a vSphere server.
+type fakeManager struct {
+	session *Session
+	params  []*Params
+}
+
+func (m *fakeManager) GetOrCreate(_ context.Context, params *Params) (*Session, error) {
+	m.params = append(m.params, params)
+	return m.session, nil
+}
+
+func TestManager(t *testing.T) {
+	// getSession stands in for code that depends on a Manager.
+	getSession := func(m Manager, server string) (*Session, error) {
+		return m.GetOrCreate(context.Background(), NewParams().WithServer(server).WithUserInfo("user", "pass"))
+	}
+
+	fake := &fakeManager{session: &Session{}}
+	s, err := getSession(fake, "vcenter.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != fake.session {
+		t.Error("expected the fake session to be returned")
+	}
+	if len(fake.params) != 1 || fake.params[0].server != "vcenter.local" {
+		t.Errorf("expected the fake to be called with server %q, got %v", "vcenter.local", fake.params)
+	}
+
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	pass, _ := server.URL.User.Password()
+	params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)
+	s, err = NewManager().GetOrCreate(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := GetOrCreate(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Client != cached.Client {
+		t.Error("expected the default manager to share the session cache with GetOrCreate")
+	}
+}
+
+func TestFindByProviderID(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)
+
+	s, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	if vm.Config.Uuid == vm.Config.InstanceUuid {
+		t.Fatal("expected the simulated VM to have distinct BIOS and instance UUIDs")
+	}
+
+	testCases := []struct {
+		name       string
+		providerID string
+	}{
+		{
+			name:       "BIOS UUID",
+			providerID: util.ConvertUUIDToProviderID(vm.Config.Uuid),
+		},
+		{
+			name:       "instance UUID",
+			providerID: util.ConvertInstanceUUIDToProviderID(vm.Config.InstanceUuid),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := s.FindByProviderID(ctx, tc.providerID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ref == nil || ref.Reference() != vm.Reference() {
+				t.Errorf("expected VM %v, got %v", vm.Reference(), ref)
+			}
+		})
+	}
+
+	// A BIOS UUID must not be looked up as an instance UUID.
+	ref, err := s.FindByProviderID(ctx, util.ConvertInstanceUUIDToProviderID(vm.Config.Uuid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref != nil {
+		t.Errorf("expected no VM, got %v", ref)
+	}
+
+	if _, err := s.FindByProviderID(ctx, "invalid"); err == nil {
+		t.Error("expected an error for an invalid provider ID")
+	}
+}
+
+func TestSessionWithContext(t *testing.T) {
+	model := simulator.VPX()
+	model.Host = 0
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	defer model.Remove()
+	// Delay searches so that the session's context is cancelled while one
+	// is in flight.
+	model.DelayConfig.MethodDelay = map[string]int{"FindByUuid": 1000}
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	pass, _ := server.URL.User.Password()
+	params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)
+	s, err := GetOrCreate(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = s.WithContext(ctx).FindByBIOSUUID(context.Background(), "12345678-1234-1234-1234-123456789abc")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the search to be cancelled, took %v", elapsed)
+	}
+
+	if _, err := s.WithContext(ctx).FinderForDatacenter(context.Background(), "DC0"); err == nil {
+		t.Error("expected an error from a session whose context is cancelled")
+	}
+	// The original session is not bound to the cancelled context.
+	if _, err := s.FinderForDatacenter(context.Background(), "DC0"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFindAllByInstanceUUID(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	params := NewParams().WithServer(server.URL.Host).WithInsecure(true).WithUserInfo(server.URL.User.Username(), pass)
+
+	s, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]types.ManagedObjectReference{}
+	for _, obj := range simulator.Map.All("VirtualMachine") {
+		vm := obj.(*simulator.VirtualMachine)
+		expected[vm.Config.InstanceUuid] = vm.Reference()
+	}
+	if len(expected) < 2 {
+		t.Fatalf("expected at least two simulated VMs, got %d", len(expected))
+	}
+
+	const missing = "00000000-0000-0000-0000-000000000000"
+	uuids := []string{missing}
+	for uuid := range expected {
+		uuids = append(uuids, uuid)
+	}
+
+	refs, err := s.FindAllByInstanceUUID(ctx, uuids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != len(uuids) {
+		t.Fatalf("expected %d results, got %d", len(uuids), len(refs))
+	}
+	for uuid, ref := range expected {
+		if refs[uuid] == nil || refs[uuid].Reference() != ref {
+			t.Errorf("expected VM %v for uuid %q, got %v", ref, uuid, refs[uuid])
+		}
+	}
+	if ref, ok := refs[missing]; !ok || ref != nil {
+		t.Errorf("expected nil for missing uuid %q, got %v", missing, ref)
+	}
+}
+
+// countingRoundTripper counts the requests sent through it.
+type countingRoundTripper struct {
+	soap.RoundTripper
+	count int32
+}
+
+func (c *countingRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	atomic.AddInt32(&c.count, 1)
+	return c.RoundTripper.RoundTrip(ctx, req, res)
+}
+
+func TestFinderForDatacenterCache(t *testing.T) {
+	model := simulator.VPX()
+	model.Datacenter = 2
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	defer model.Remove()
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	params := NewParams().
+		WithServer(server.URL.Host).
+		WithInsecure(true).
+		WithDatacenter("DC0").
+		WithUserInfo(server.URL.User.Username(), pass)
+
+	s, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := &countingRoundTripper{RoundTripper: s.Client.Client.RoundTripper}
+	s.Client.Client.RoundTripper = counter
+
+	// The session's own datacenter was resolved when it was created.
+	if _, err := s.FinderForDatacenter(ctx, "DC0"); err != nil {
+		t.Fatal(err)
+	}
+	if count := atomic.LoadInt32(&counter.count); count != 0 {
+		t.Errorf("expected no requests for the session's datacenter, got %d", count)
+	}
+
+	if _, err := s.FinderForDatacenter(ctx, "DC1"); err != nil {
+		t.Fatal(err)
+	}
+	first := atomic.LoadInt32(&counter.count)
+	if first == 0 {
+		t.Fatal("expected requests to resolve datacenter DC1")
+	}
+
+	// A copy of the session shares the cache.
+	cached, err := GetOrCreate(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first = atomic.LoadInt32(&counter.count)
+	if _, err := cached.FinderForDatacenter(ctx, "DC1"); err != nil {
+		t.Fatal(err)
+	}
+	if count := atomic.LoadInt32(&counter.count); count != first {
+		t.Errorf("expected no further requests for datacenter DC1, got %d", count-first)
+	}
+
+	// Evicting the session invalidates the cache.
+	Evict(params)
+	if _, err := s.FinderForDatacenter(ctx, "DC1"); err != nil {
+		t.Fatal(err)
+	}
+	if count := atomic.LoadInt32(&counter.count); count == first {
+		t.Error("expected datacenter DC1 to be resolved again after eviction")
+	}
+}
+
+// logEntry is a message logged by a recordingLogger with its key/value
+// pairs, including those added by WithValues.
+type logEntry struct {
+	level  int
+	msg    string
+	values map[string]interface{}
+}
+
+// recordingLogger is a logr.Logger that records the messages logged through
+// it and any logger derived from it.
+type recordingLogger struct {
+	mu      *sync.Mutex
+	entries *[]logEntry
+	level   int
+	values  []interface{}
+}
+
+func newRecordingLogger() recordingLogger {
+	return recordingLogger{mu: &sync.Mutex{}, entries: &[]logEntry{}}
+}
+
+func (l recordingLogger) Enabled() bool { return true }
+
+func (l recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	values := map[string]interface{}{}
+	kvs := append(append([]interface{}{}, l.values...), keysAndValues...)
+	for i := 0; i+1 < len(kvs); i += 2 {
+		values[kvs[i].(string)] = kvs[i+1]
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.entries = append(*l.entries, logEntry{level: l.level, msg: msg, values: values})
+}
+
+func (l recordingLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.Info(msg, append(keysAndValues, "error", err)...)
+}
+
+func (l recordingLogger) V(level int) logr.InfoLogger {
+	l.level = level
+	return l
+}
+
+func (l recordingLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	l.values = append(append([]interface{}{}, l.values...), keysAndValues...)
+	return l
+}
+
+func (l recordingLogger) WithName(_ string) logr.Logger { return l }
+
+func (l recordingLogger) find(msg string) (logEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, entry := range *l.entries {
+		if entry.msg == msg {
+			return entry, true
+		}
+	}
+	return logEntry{}, false
+}
+
+func TestGetOrCreateLogging(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	logger := newRecordingLogger()
+	params := NewParams().
+		WithServer(server.URL.Host).
+		WithInsecure(true).
+		WithDatacenter("DC0").
+		WithUserInfo(server.URL.User.Username(), pass).
+		WithLogger(logger)
+
+	if _, err := GetOrCreate(ctx, params); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetOrCreate(ctx, params); err != nil {
+		t.Fatal(err)
+	}
+	Evict(params)
+
+	expectedValues := map[string]interface{}{
+		"server":     server.URL.Host,
+		"datacenter": "DC0",
+		"username":   server.URL.User.Username(),
+	}
+	for _, msg := range []string{
+		"no cached vSphere client session",
+		"logged into vSphere server",
+		"cached vSphere client session",
+		"found cached vSphere client session",
+		"evicted vSphere client session",
+	} {
+		entry, ok := logger.find(msg)
+		if !ok {
+			t.Errorf("expected %q to be logged", msg)
+			continue
+		}
+		if entry.level != 2 {
+			t.Errorf("expected %q to be logged at V(2), got V(%d)", msg, entry.level)
+		}
+		for key, expected := range expectedValues {
+			if actual := entry.values[key]; actual != expected {
+				t.Errorf("expected %q to be logged with %s=%v, got %v", msg, key, expected, actual)
+			}
+		}
+	}
+}
+
+func TestAboutInfo(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	s, err := GetOrCreate(ctx, NewParams().
+		WithServer(server.URL.Host).
+		WithInsecure(true).
+		WithUserInfo(server.URL.User.Username(), pass))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	about := s.AboutInfo()
+	if about.ApiType != "VirtualCenter" {
+		t.Errorf("expected API type VirtualCenter, got %q", about.ApiType)
+	}
+	if about.ApiVersion != vpx.ServiceContent.About.ApiVersion {
+		t.Errorf("expected API version %q, got %q", vpx.ServiceContent.About.ApiVersion, about.ApiVersion)
+	}
+
+	testCases := []struct {
+		min      string
+		expected bool
+	}{
+		{min: "5.5", expected: true},
+		{min: about.ApiVersion, expected: true},
+		{min: about.ApiVersion + ".1", expected: false},
+		{min: "99.0", expected: false},
+		{min: "not-a-version", expected: false},
+	}
+	for _, tc := range testCases {
+		if actual := s.SupportsAPIVersion(tc.min); actual != tc.expected {
+			t.Errorf("expected SupportsAPIVersion(%q) with API version %q to be %t", tc.min, about.ApiVersion, tc.expected)
+		}
+	}
+
+	if (&Session{}).SupportsAPIVersion("5.5") {
+		t.Error("expected an uninitialized session not to support any API version")
+	}
+}
+
+func TestFindObjectByPath(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	s, err := GetOrCreate(ctx, NewParams().
+		WithServer(server.URL.Host).
+		WithInsecure(true).
+		WithDatacenter("DC0").
+		WithUserInfo(server.URL.User.Username(), pass))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		kind     string
+		path     string
+		expected string
+	}{
+		{kind: ObjectKindDatastore, path: "LocalDS_0", expected: "Datastore"},
+		{kind: ObjectKindDatastore, path: "/DC0/datastore/LocalDS_0", expected: "Datastore"},
+		{kind: ObjectKindFolder, path: "vm", expected: "Folder"},
+		{kind: ObjectKindFolder, path: "/DC0/vm", expected: "Folder"},
+		{kind: ObjectKindResourcePool, path: "/DC0/host/DC0_C0/Resources", expected: "ResourcePool"},
+		{kind: ObjectKindNetwork, path: "VM Network", expected: "Network"},
+		{kind: ObjectKindNetwork, path: "/DC0/network/DC0_DVPG0", expected: "DistributedVirtualPortgroup"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.kind+" "+tc.path, func(t *testing.T) {
+			ref, err := s.FindObjectByPath(ctx, tc.kind, tc.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ref.Reference().Type != tc.expected {
+				t.Errorf("expected a %s, got %v", tc.expected, ref.Reference())
+			}
+		})
+	}
+
+	_, err = s.FindObjectByPath(ctx, ObjectKindDatastore, "missing")
+	var notFound *ObjectNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected an ObjectNotFoundError, got %v", err)
+	}
+	if notFound.Kind != ObjectKindDatastore || notFound.Path != "missing" {
+		t.Errorf("unexpected not found error %+v", notFound)
+	}
+
+	if _, err := s.FindObjectByPath(ctx, "VirtualMachine", "DC0_H0_VM0"); err == nil {
+		t.Error("expected an error for an unsupported kind")
+	}
+}
+
+func TestEnsureFolder(t *testing.T) {
+	model, server := newSimulator(t)
+	defer model.Remove()
+	defer server.Close()
+
+	ctx := context.Background()
+	pass, _ := server.URL.User.Password()
+	s, err := GetOrCreate(ctx, NewParams().
+		WithServer(server.URL.Host).
+		WithInsecure(true).
+		WithDatacenter("DC0").
+		WithUserInfo(server.URL.User.Username(), pass))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A nested path that does not exist is created in full.
+	if _, err := s.Finder.Folder(ctx, "/DC0/vm/a"); err == nil {
+		t.Fatal("expected folder /DC0/vm/a not to exist")
+	}
+	created, err := s.EnsureFolder(ctx, "a/b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.InventoryPath != "/DC0/vm/a/b/c" {
+		t.Errorf("expected inventory path /DC0/vm/a/b/c, got %q", created.InventoryPath)
+	}
+	found, err := s.Finder.Folder(ctx, "/DC0/vm/a/b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Reference() != created.Reference() {
+		t.Errorf("expected %v, got %v", found.Reference(), created.Reference())
+	}
+
+	// Existing folders are returned rather than created again.
+	existing, err := s.EnsureFolder(ctx, "/DC0/vm/a/b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if existing.Reference() != created.Reference() {
+		t.Errorf("expected %v, got %v", created.Reference(), existing.Reference())
+	}
+
+	// Only the missing part of a path is created.
+	sibling, err := s.EnsureFolder(ctx, "/DC0/vm/a/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Finder.Folder(ctx, sibling.InventoryPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// Folders are not created outside the datacenter's VM folder.
+	if _, err := s.EnsureFolder(ctx, "/DC1/vm/x"); err == nil {
+		t.Error("expected an error creating a folder outside the VM folder")
+	}
+	if _, err := s.Finder.Folder(ctx, "/DC1"); err == nil {
+		t.Error("expected no folder to be created for a missing datacenter")
+	}
+}
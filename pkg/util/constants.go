@@ -17,8 +17,8 @@ limitations under the License.
 package util
 
 const metadataFormat = `
-instance-id: "{{ .Hostname }}"
-local-hostname: "{{ .Hostname }}"
+instance-id: "{{ .InstanceID }}"
+local-hostname: "{{ .Hostname }}{{ if .Domain }}.{{ .Domain }}{{ end }}"
 wait-on-network:
   ipv4: {{ .WaitForIPv4 }}
   ipv6: {{ .WaitForIPv6 }}
@@ -35,21 +35,71 @@ network:
       set-name: "eth{{ $i }}"
       {{- end }}
       wakeonlan: true
-      {{- if or $net.DHCP4 $net.DHCP6 }}
-      dhcp4: {{ $net.DHCP4 }}
-      dhcp6: {{ $net.DHCP6 }}
+      {{- if or $net.VLAN (index $.Bonded $i) }}
+      {{- if $net.MTU }}
+      mtu: {{ $net.MTU }}
       {{- end }}
-      {{- if $net.IPAddrs }}
+      {{- else }}
+      {{- template "addressing" $net }}
+      {{- end }}
+    {{- end }}
+  {{- if .Bonds }}
+  bonds:
+    {{- range .Bonds }}
+    "{{ .Name }}":
+      interfaces:
+      {{- range .Interfaces }}
+      - {{ . }}
+      {{- end }}
+      parameters:
+        mode: "{{ .Mode }}"
+        mii-monitor-interval: 100
+        {{- if eq .Mode "active-backup" }}
+        primary: {{ index .Interfaces 0 }}
+        {{- end }}
+      {{- template "addressing" .Device }}
+    {{- end }}
+  {{- end }}
+  {{- if vlans .Devices }}
+  vlans:
+    {{- range $i, $net := .Devices }}
+    {{- if $net.VLAN }}
+    "{{ if $net.DeviceName }}{{ $net.DeviceName }}{{ else }}eth{{ $i }}{{ end }}.{{ $net.VLAN }}":
+      id: {{ $net.VLAN }}
+      link: id{{ $i }}
+      {{- template "addressing" $net }}
+    {{- end }}
+    {{- end }}
+  {{- end }}
+  {{- if .Routes }}
+  routes:
+  {{- range .Routes }}
+  - to: "{{ .To }}"
+    via: "{{ .Via }}"
+    metric: {{ .Metric }}
+  {{- end }}
+  {{- end }}
+{{- range $key, $value := .Extra }}
+{{ quote $key }}: {{ quote $value }}
+{{- end }}
+{{- define "addressing" }}
+      {{- if or .DHCP4 .DHCP6 }}
+      {{- if ipv4 . }}
+      dhcp4: {{ .DHCP4 }}
+      {{- end }}
+      dhcp6: {{ .DHCP6 }}
+      {{- end }}
+      {{- if .IPAddrs }}
       addresses:
-      {{- range $net.IPAddrs }}
+      {{- range .IPAddrs }}
       - "{{ . }}"
       {{- end }}
       {{- end }}
-      {{- if $net.Gateway4 }}
-      gateway4: "{{ $net.Gateway4 }}"
+      {{- if .Gateway4 }}
+      gateway4: "{{ .Gateway4 }}"
       {{- end }}
-      {{- if $net.Gateway6 }}
-      gateway6: "{{ $net.Gateway6 }}"
+      {{- if .Gateway6 }}
+      gateway6: "{{ .Gateway6 }}"
       {{- end }}
       {{- if .MTU }}
       mtu: {{ .MTU }}
@@ -62,28 +112,20 @@ network:
         metric: {{ .Metric }}
       {{- end }}
       {{- end }}
-      {{- if nameservers $net }}
+      {{- if nameservers . }}
       nameservers:
-        {{- if $net.Nameservers }}
+        {{- if .Nameservers }}
         addresses:
-        {{- range $net.Nameservers }}
+        {{- range .Nameservers }}
         - "{{ . }}"
         {{- end }}
         {{- end }}
-        {{- if $net.SearchDomains }}
+        {{- if .SearchDomains }}
         search:
-        {{- range $net.SearchDomains }}
+        {{- range .SearchDomains }}
         - "{{ . }}"
         {{- end }}
         {{- end }}
       {{- end }}
-    {{- end }}
-  {{- if .Routes }}
-  routes:
-  {{- range .Routes }}
-  - to: "{{ .To }}"
-    via: "{{ .Via }}"
-    metric: {{ .Metric }}
-  {{- end }}
-  {{- end }}
+{{- end }}
 `
@@ -19,17 +19,24 @@ package util
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"net"
 	"regexp"
+	"sort"
+	"strings"
 	"text/template"
 
 	"github.com/pkg/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	apitypes "k8s.io/apimachinery/pkg/types"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/constants"
 )
 
 // GetMachinesInCluster gets a cluster's Machine resources.
@@ -38,21 +45,133 @@ func GetMachinesInCluster(
 	controllerClient client.Client,
 	namespace, clusterName string) ([]*clusterv1.Machine, error) {
 
+	var machines []*clusterv1.Machine
+	if err := ForEachMachinesPageInCluster(
+		ctx, controllerClient, namespace, clusterName, 0,
+		func(page []*clusterv1.Machine) error {
+			machines = append(machines, page...)
+			return nil
+		}); err != nil {
+		return nil, err
+	}
+	if machines == nil {
+		machines = []*clusterv1.Machine{}
+	}
+
+	return machines, nil
+}
+
+// ForEachMachinesPageInCluster lists a cluster's Machine resources in pages
+// of at most limit machines, calling fn with each page so that callers may
+// process very large clusters without holding every machine at once. A
+// limit of zero lists all of the machines in a single page. Listing stops
+// at the first error returned by fn.
+func ForEachMachinesPageInCluster(
+	ctx context.Context,
+	controllerClient client.Client,
+	namespace, clusterName string,
+	limit int64,
+	fn func([]*clusterv1.Machine) error) error {
+
 	labels := map[string]string{clusterv1.ClusterLabelName: clusterName}
+	continueToken := ""
+
+	for {
+		machineList := &clusterv1.MachineList{}
+		opts := []client.ListOption{
+			client.InNamespace(namespace),
+			client.MatchingLabels(labels),
+		}
+		if limit > 0 {
+			opts = append(opts, client.Limit(limit))
+		}
+		if continueToken != "" {
+			opts = append(opts, client.Continue(continueToken))
+		}
+		if err := controllerClient.List(ctx, machineList, opts...); err != nil {
+			return errors.Wrapf(
+				err, "error getting machines in cluster %s/%s",
+				namespace, clusterName)
+		}
+
+		machines := make([]*clusterv1.Machine, len(machineList.Items))
+		for i := range machineList.Items {
+			machines[i] = &machineList.Items[i]
+		}
+		if err := fn(machines); err != nil {
+			return err
+		}
+
+		continueToken = machineList.Continue
+		if continueToken == "" {
+			return nil
+		}
+	}
+}
+
+// GetControlPlaneMachinesInCluster gets a cluster's control plane Machine
+// resources. Machines that are recognized as control plane only by their
+// owner reference cannot be selected by label, so the cluster's machines
+// are filtered with IsControlPlaneMachine.
+func GetControlPlaneMachinesInCluster(
+	ctx context.Context,
+	controllerClient client.Client,
+	namespace, clusterName string) ([]*clusterv1.Machine, error) {
+
+	machines, err := GetMachinesInCluster(ctx, controllerClient, namespace, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	controlPlaneMachines := make([]*clusterv1.Machine, 0, len(machines))
+	for _, machine := range machines {
+		if IsControlPlaneMachine(machine) {
+			controlPlaneMachines = append(controlPlaneMachines, machine)
+		}
+	}
+
+	return controlPlaneMachines, nil
+}
+
+// GetWorkerMachinesInCluster gets a cluster's worker Machine resources.
+// Machines with the control plane label are excluded by the API server;
+// machines owned by a control plane are then excluded by
+// IsControlPlaneMachine.
+func GetWorkerMachinesInCluster(
+	ctx context.Context,
+	controllerClient client.Client,
+	namespace, clusterName string) ([]*clusterv1.Machine, error) {
+
+	selector, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
+		MatchLabels: map[string]string{clusterv1.ClusterLabelName: clusterName},
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{
+				Key:      clusterv1.MachineControlPlaneLabelName,
+				Operator: metav1.LabelSelectorOpDoesNotExist,
+			},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(
+			err, "error getting worker machines in cluster %s/%s",
+			namespace, clusterName)
+	}
 	machineList := &clusterv1.MachineList{}
 
 	if err := controllerClient.List(
 		ctx, machineList,
 		client.InNamespace(namespace),
-		client.MatchingLabels(labels)); err != nil {
+		client.MatchingLabelsSelector{Selector: selector}); err != nil {
 		return nil, errors.Wrapf(
-			err, "error getting machines in cluster %s/%s",
+			err, "error getting worker machines in cluster %s/%s",
 			namespace, clusterName)
 	}
 
-	machines := make([]*clusterv1.Machine, len(machineList.Items))
+	machines := make([]*clusterv1.Machine, 0, len(machineList.Items))
 	for i := range machineList.Items {
-		machines[i] = &machineList.Items[i]
+		if !IsControlPlaneMachine(&machineList.Items[i]) {
+			machines = append(machines, &machineList.Items[i])
+		}
 	}
 
 	return machines, nil
@@ -82,6 +201,32 @@ func GetVSphereMachinesInCluster(
 	return machines, nil
 }
 
+// GetVSphereMachinesInClusterByName gets a cluster's VSphereMachine
+// resources keyed by name. An error is returned if more than one
+// VSphereMachine has the same name.
+func GetVSphereMachinesInClusterByName(
+	ctx context.Context,
+	controllerClient client.Client,
+	namespace, clusterName string) (map[string]*infrav1.VSphereMachine, error) {
+
+	machines, err := GetVSphereMachinesInCluster(ctx, controllerClient, namespace, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	machinesByName := make(map[string]*infrav1.VSphereMachine, len(machines))
+	for _, machine := range machines {
+		if _, ok := machinesByName[machine.Name]; ok {
+			return nil, errors.Errorf(
+				"duplicate VSphereMachine %s/%s in cluster %s/%s",
+				machine.Namespace, machine.Name, namespace, clusterName)
+		}
+		machinesByName[machine.Name] = machine
+	}
+
+	return machinesByName, nil
+}
+
 // GetVSphereMachine gets a VSphereMachine resource for the given CAPI Machine.
 func GetVSphereMachine(
 	ctx context.Context,
@@ -102,26 +247,69 @@ func GetVSphereMachine(
 // ErrNoMachineIPAddr indicates that no valid IP addresses were found in a machine context
 var ErrNoMachineIPAddr = errors.New("no IP addresses found for machine")
 
-// GetMachinePreferredIPAddress returns the preferred IP address for a
-// VSphereMachine resource.
+// AddressTypePreference selects which machine address types are considered
+// when choosing a machine's preferred IP address.
+type AddressTypePreference string
+
+const (
+	// AddressTypePreferenceExternal considers only external IP addresses.
+	AddressTypePreferenceExternal AddressTypePreference = "External"
+
+	// AddressTypePreferenceInternal considers only internal IP addresses.
+	AddressTypePreferenceInternal AddressTypePreference = "Internal"
+
+	// AddressTypePreferenceAny considers both external and internal IP
+	// addresses, in the order in which they are reported.
+	AddressTypePreferenceAny AddressTypePreference = "Any"
+)
+
+// matches returns true if the address type is allowed by the preference.
+func (p AddressTypePreference) matches(addrType clusterv1.MachineAddressType) bool {
+	switch p {
+	case AddressTypePreferenceInternal:
+		return addrType == clusterv1.MachineInternalIP
+	case AddressTypePreferenceAny:
+		return addrType == clusterv1.MachineExternalIP || addrType == clusterv1.MachineInternalIP
+	default:
+		return addrType == clusterv1.MachineExternalIP
+	}
+}
+
+// GetMachinePreferredIPAddress returns the preferred external IP address for
+// a VSphereMachine resource. The preferred API server CIDR may be a
+// comma-separated list of CIDRs, in which case the first address contained
+// by any of them is returned.
 func GetMachinePreferredIPAddress(machine *infrav1.VSphereMachine) (string, error) {
-	var cidr *net.IPNet
-	if cidrString := machine.Spec.Network.PreferredAPIServerCIDR; cidrString != "" {
-		var err error
-		if _, cidr, err = net.ParseCIDR(cidrString); err != nil {
-			return "", errors.New("error parsing preferred API server CIDR")
+	return GetMachinePreferredIPAddressOfType(machine, AddressTypePreferenceExternal)
+}
+
+// GetMachinePreferredIPAddressOfType is like GetMachinePreferredIPAddress,
+// but only considers addresses whose type is allowed by the given preference.
+func GetMachinePreferredIPAddressOfType(machine *infrav1.VSphereMachine, preference AddressTypePreference) (string, error) {
+	var cidrs []*net.IPNet
+	for _, cidrString := range strings.Split(machine.Spec.Network.PreferredAPIServerCIDR, ",") {
+		if cidrString = strings.TrimSpace(cidrString); cidrString == "" {
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(cidrString)
+		if err != nil {
+			return "", errors.Wrapf(err, "error parsing preferred API server CIDR %q", cidrString)
 		}
+		cidrs = append(cidrs, cidr)
 	}
 
 	for _, machineAddr := range machine.Status.Addresses {
-		if machineAddr.Type != clusterv1.MachineExternalIP {
+		if !preference.matches(machineAddr.Type) {
 			continue
 		}
-		if cidr == nil {
+		if len(cidrs) == 0 {
 			return machineAddr.Address, nil
 		}
-		if cidr.Contains(net.ParseIP(machineAddr.Address)) {
-			return machineAddr.Address, nil
+		ip := net.ParseIP(machineAddr.Address)
+		for _, cidr := range cidrs {
+			if cidr.Contains(ip) {
+				return machineAddr.Address, nil
+			}
 		}
 	}
 
@@ -129,21 +317,139 @@ func GetMachinePreferredIPAddress(machine *infrav1.VSphereMachine) (string, erro
 }
 
 // IsControlPlaneMachine returns true if the provided resource is
-// a member of the control plane.
+// a member of the control plane, either because it has the control plane
+// label or because it is owned by a KubeadmControlPlane. The owner
+// reference may be set before the label during provisioning.
 func IsControlPlaneMachine(machine metav1.Object) bool {
-	_, ok := machine.GetLabels()[clusterv1.MachineControlPlaneLabelName]
-	return ok
+	if _, ok := machine.GetLabels()[clusterv1.MachineControlPlaneLabelName]; ok {
+		return true
+	}
+	for _, ref := range machine.GetOwnerReferences() {
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil {
+			continue
+		}
+		if gv.Group == controlplanev1.GroupVersion.Group && ref.Kind == "KubeadmControlPlane" {
+			return true
+		}
+	}
+	return false
 }
 
-// GetMachineMetadata returns the cloud-init metadata as a base-64 encoded
-// string for a given VSphereMachine.
-func GetMachineMetadata(hostname string, machine infrav1.VSphereVM, networkStatus ...infrav1.NetworkStatus) ([]byte, error) {
+// MachineMetadata is the data from which a machine's cloud-init metadata is
+// rendered.
+type MachineMetadata struct {
+	// InstanceID identifies the machine to cloud-init, which re-runs its
+	// first-boot modules when the instance-id changes. It is the
+	// VSphereVM's name, or the hostname if the name is empty, so that it is
+	// the same every time the metadata is rendered. The BIOS UUID is not
+	// used because it is assigned only after the metadata is first
+	// rendered for the clone.
+	InstanceID string
+
+	// Hostname is the machine's short hostname. It determines the Kubernetes
+	// node name.
+	Hostname string
+
+	// Domain is the optional DNS domain appended to the local hostname.
+	Domain string
+
+	// Devices are the machine's network devices, with their MAC addresses
+	// taken from the network status when available.
+	Devices []infrav1.NetworkDeviceSpec
+
+	// Routes are the machine's static routes.
+	Routes []infrav1.NetworkRouteSpec
+
+	// WaitForIPv4 is true if any device has a static IPv4 address or uses
+	// DHCP4, unless waiting on the network is skipped.
+	WaitForIPv4 bool
+
+	// WaitForIPv6 is true if any device has a static IPv6 address or uses
+	// DHCP6, unless waiting on the network is skipped.
+	WaitForIPv6 bool
+
+	// Extra are additional metadata keys and values taken from the
+	// VSphereVM's annotations prefixed with MetadataAnnotationPrefix. Keys
+	// that are rendered from the other fields are excluded.
+	Extra map[string]string
+
+	// Bonds are the machine's bonded interfaces.
+	Bonds []MachineBond
+
+	// Bonded reports, for each device, whether it is a member of a bond.
+	// Bond members are rendered without their own addresses.
+	Bonded []bool
+}
+
+// MachineBond is the data from which a bonded interface is rendered.
+type MachineBond struct {
+	// Name is the name of the bonded interface in the guest.
+	Name string
+
+	// Mode is the bonding mode.
+	Mode infrav1.BondMode
+
+	// Interfaces are the netplan IDs of the bond's members, such as id0.
+	// The first is the primary member in active-backup mode.
+	Interfaces []string
+
+	// Device is the bond's first member, whose addresses, gateways, routes,
+	// and nameservers are applied to the bond.
+	Device infrav1.NetworkDeviceSpec
+}
+
+// coreMetadataKeys are the top-level metadata keys rendered from the
+// VSphereVM's spec, which may not be set by annotations.
+var coreMetadataKeys = map[string]bool{
+	"instance-id":     true,
+	"local-hostname":  true,
+	"wait-on-network": true,
+	"network":         true,
+}
+
+// extraMetadata returns the metadata keys and values set by the VSphereVM's
+// annotations, or nil if there are none.
+func extraMetadata(machine infrav1.VSphereVM) map[string]string {
+	var extra map[string]string
+	for name, value := range machine.Annotations {
+		if !strings.HasPrefix(name, constants.MetadataAnnotationPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(name, constants.MetadataAnnotationPrefix)
+		if key == "" || coreMetadataKeys[key] {
+			continue
+		}
+		if extra == nil {
+			extra = map[string]string{}
+		}
+		extra[key] = value
+	}
+	return extra
+}
+
+// BuildMachineMetadata returns the data from which the cloud-init metadata
+// for a given VSphereVM is rendered. It may be used to inspect or log the
+// metadata before it is templated.
+func BuildMachineMetadata(hostname string, machine infrav1.VSphereVM, networkStatus ...infrav1.NetworkStatus) (MachineMetadata, error) {
+	for i := range machine.Spec.Network.Devices {
+		if err := validateNetworkDevice(machine.Spec.Network.Devices[i]); err != nil {
+			return MachineMetadata{}, errors.Wrapf(err, "invalid network device %d", i)
+		}
+	}
+
 	// Create a copy of the devices and add their MAC addresses from a network status.
 	devices := make([]infrav1.NetworkDeviceSpec, len(machine.Spec.Network.Devices))
 	var waitForIPv4, waitForIPv6 bool
 	for i := range machine.Spec.Network.Devices {
 		machine.Spec.Network.Devices[i].DeepCopyInto(&devices[i])
-		if len(networkStatus) > 0 {
+		devices[i].Nameservers = dedupNameservers(devices[i].Nameservers)
+		devices[i].SearchDomains = dedupSearchDomains(devices[i].SearchDomains)
+		// The network status may have fewer entries than there are devices
+		// while the NICs are still being attached, or may not yet report a
+		// NIC's MAC address. A MAC address from the device spec is kept so
+		// the device is still matched by it.
+		if i < len(networkStatus) && networkStatus[i].MACAddr != "" {
 			devices[i].MACAddr = networkStatus[i].MACAddr
 		}
 
@@ -153,7 +459,7 @@ func GetMachineMetadata(hostname string, machine infrav1.VSphereVM, networkStatu
 		}
 		// check static IPs
 		for _, ipStr := range machine.Spec.Network.Devices[i].IPAddrs {
-			ip := net.ParseIP(ipStr)
+			ip := parseIPOrCIDR(ipStr)
 			// check the IP family
 			if ip != nil {
 				if ip.To4() == nil {
@@ -172,26 +478,329 @@ func GetMachineMetadata(hostname string, machine infrav1.VSphereVM, networkStatu
 		}
 	}
 
-	buf := &bytes.Buffer{}
-	tpl := template.Must(template.New("t").Funcs(
-		template.FuncMap{
-			"nameservers": func(spec infrav1.NetworkDeviceSpec) bool {
-				return len(spec.Nameservers) > 0 || len(spec.SearchDomains) > 0
-			},
-		}).Parse(metadataFormat))
-	if err := tpl.Execute(buf, struct {
-		Hostname    string
-		Devices     []infrav1.NetworkDeviceSpec
-		Routes      []infrav1.NetworkRouteSpec
-		WaitForIPv4 bool
-		WaitForIPv6 bool
-	}{
-		Hostname:    hostname, // note that hostname determines the Kubernetes node name
+	if machine.Spec.Network.SkipWaitOnNetwork {
+		waitForIPv4, waitForIPv6 = false, false
+	}
+
+	var routes []infrav1.NetworkRouteSpec
+	if machine.Spec.Network.Routes != nil {
+		routes = make([]infrav1.NetworkRouteSpec, len(machine.Spec.Network.Routes))
+		copy(routes, machine.Spec.Network.Routes)
+	}
+
+	if preferred := machine.Spec.Network.AddressFamilyPreference; preferred != "" {
+		for i := range devices {
+			sortByFamily(devices[i].IPAddrs, preferred)
+			sortByFamily(devices[i].Nameservers, preferred)
+			sortRoutesByFamily(devices[i].Routes, preferred)
+		}
+		sortRoutesByFamily(routes, preferred)
+	}
+
+	bonds, bonded, err := buildBonds(machine.Spec.Network.Bonds, devices)
+	if err != nil {
+		return MachineMetadata{}, err
+	}
+
+	// Devices without search domains of their own inherit the defaults.
+	// This is done after the bonds are built so that the defaults are not
+	// mistaken for addresses set on a bond's secondary members.
+	if defaults := dedupSearchDomains(machine.Spec.Network.DefaultSearchDomains); len(defaults) > 0 {
+		for i := range devices {
+			if len(devices[i].SearchDomains) == 0 {
+				devices[i].SearchDomains = defaults
+			}
+		}
+		for i := range bonds {
+			if len(bonds[i].Device.SearchDomains) == 0 {
+				bonds[i].Device.SearchDomains = defaults
+			}
+		}
+	}
+
+	instanceID := machine.Name
+	if instanceID == "" {
+		instanceID = hostname
+	}
+
+	return MachineMetadata{
+		InstanceID:  instanceID,
+		Hostname:    hostname,
+		Domain:      strings.Trim(machine.Spec.Network.Domain, "."),
 		Devices:     devices,
-		Routes:      machine.Spec.Network.Routes,
+		Routes:      routes,
 		WaitForIPv4: waitForIPv4,
 		WaitForIPv6: waitForIPv6,
-	}); err != nil {
+		Extra:       extraMetadata(machine),
+		Bonds:       bonds,
+		Bonded:      bonded,
+	}, nil
+}
+
+// deviceName returns the name in the guest of the device at the given
+// index.
+func deviceName(i int, device infrav1.NetworkDeviceSpec) string {
+	if device.DeviceName != "" {
+		return device.DeviceName
+	}
+	return fmt.Sprintf("eth%d", i)
+}
+
+// buildBonds returns the bonds to render and, for each device, whether it
+// is a bond member. An error is returned if a bond names a device that
+// does not exist, shares a device with another bond, or aggregates a
+// device that has a VLAN or, other than its first member, addresses of its
+// own.
+func buildBonds(specs []infrav1.NetworkBondSpec, devices []infrav1.NetworkDeviceSpec) ([]MachineBond, []bool, error) {
+	bonded := make([]bool, len(devices))
+	if len(specs) == 0 {
+		return nil, bonded, nil
+	}
+
+	indexes := make(map[string]int, len(devices))
+	for i := range devices {
+		indexes[deviceName(i, devices[i])] = i
+	}
+
+	bonds := make([]MachineBond, 0, len(specs))
+	names := map[string]bool{}
+	for _, spec := range specs {
+		if spec.Name == "" {
+			return nil, nil, errors.New("bond has no name")
+		}
+		if names[spec.Name] {
+			return nil, nil, errors.Errorf("bond %q is defined more than once", spec.Name)
+		}
+		names[spec.Name] = true
+		if spec.Mode != infrav1.BondMode8023AD && spec.Mode != infrav1.BondModeActiveBackup {
+			return nil, nil, errors.Errorf("bond %q has unsupported mode %q", spec.Name, spec.Mode)
+		}
+		if len(spec.Members) == 0 {
+			return nil, nil, errors.Errorf("bond %q has no members", spec.Name)
+		}
+
+		bond := MachineBond{Name: spec.Name, Mode: spec.Mode}
+		for j, member := range spec.Members {
+			i, ok := indexes[member]
+			if !ok {
+				return nil, nil, errors.Errorf("bond %q member %q is not a network device", spec.Name, member)
+			}
+			if bonded[i] {
+				return nil, nil, errors.Errorf("bond %q member %q is already a bond member", spec.Name, member)
+			}
+			if devices[i].VLAN != nil {
+				return nil, nil, errors.Errorf("bond %q member %q may not have a VLAN", spec.Name, member)
+			}
+			if j > 0 && hasAddressing(devices[i]) {
+				return nil, nil, errors.Errorf(
+					"bond %q member %q may not have addresses; set them on the first member %q",
+					spec.Name, member, spec.Members[0])
+			}
+			bonded[i] = true
+			bond.Interfaces = append(bond.Interfaces, fmt.Sprintf("id%d", i))
+			if j == 0 {
+				bond.Device = devices[i]
+			}
+		}
+		bonds = append(bonds, bond)
+	}
+	return bonds, bonded, nil
+}
+
+// hasAddressing returns true if the device has addresses, gateways,
+// routes, or nameservers, or uses DHCP.
+func hasAddressing(d infrav1.NetworkDeviceSpec) bool {
+	return d.DHCP4 || d.DHCP6 || len(d.IPAddrs) > 0 || d.Gateway4 != "" || d.Gateway6 != "" ||
+		len(d.Routes) > 0 || len(d.Nameservers) > 0 || len(d.SearchDomains) > 0
+}
+
+// dedupNameservers returns the nameservers without duplicates, keeping the
+// first occurrence of each. Addresses are compared in their canonical form,
+// so fd00::1 and FD00:0::1 are the same nameserver.
+func dedupNameservers(nameservers []string) []string {
+	return dedup(nameservers, func(s string) string {
+		if ip := net.ParseIP(s); ip != nil {
+			return ip.String()
+		}
+		return s
+	})
+}
+
+// dedupSearchDomains returns the search domains without duplicates,
+// keeping the first occurrence of each. Domains are compared without case
+// or a trailing dot.
+func dedupSearchDomains(domains []string) []string {
+	return dedup(domains, func(s string) string {
+		return strings.ToLower(strings.TrimSuffix(s, "."))
+	})
+}
+
+// dedup returns values without those whose key, as returned by keyFn, was
+// already seen. The order of the remaining values is kept.
+func dedup(values []string, keyFn func(string) string) []string {
+	if len(values) == 0 {
+		return values
+	}
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		key := keyFn(v)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, v)
+	}
+	return result
+}
+
+// familyRank returns 0 if s is an IP address or CIDR of the preferred
+// family, and 1 otherwise.
+func familyRank(s string, preferred infrav1.AddressFamily) int {
+	ip := parseIPOrCIDR(s)
+	if ip == nil {
+		return 1
+	}
+	if (ip.To4() != nil) == (preferred == infrav1.AddressFamilyIPv4) {
+		return 0
+	}
+	return 1
+}
+
+// sortByFamily moves the addresses of the preferred family to the front of
+// addrs. The order within each family is kept.
+func sortByFamily(addrs []string, preferred infrav1.AddressFamily) {
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return familyRank(addrs[i], preferred) < familyRank(addrs[j], preferred)
+	})
+}
+
+// sortRoutesByFamily moves the routes to destinations of the preferred
+// family to the front of routes. The order within each family is kept.
+func sortRoutesByFamily(routes []infrav1.NetworkRouteSpec, preferred infrav1.AddressFamily) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		return familyRank(routes[i].To, preferred) < familyRank(routes[j].To, preferred)
+	})
+}
+
+// parseIPOrCIDR returns the IP address of s, which may be an IP address or
+// an address in CIDR format, or nil if s is neither.
+func parseIPOrCIDR(s string) net.IP {
+	if ip, _, err := net.ParseCIDR(s); err == nil {
+		return ip
+	}
+	return net.ParseIP(s)
+}
+
+// hasIPv4 returns true if the device uses DHCP4 or has an IPv4 address or
+// gateway. A device without any is IPv6-only.
+func hasIPv4(spec infrav1.NetworkDeviceSpec) bool {
+	if spec.DHCP4 || spec.Gateway4 != "" {
+		return true
+	}
+	for _, addr := range spec.IPAddrs {
+		if ip := parseIPOrCIDR(addr); ip != nil && ip.To4() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// validateNetworkDevice returns an error if the device's VLAN ID is out of
+// range, if one of its gateways is not a valid IP address of the right
+// family, or if the device has addresses in CIDR format of that family and
+// the gateway is in none of their subnets. A gateway outside the subnet
+// would leave the machine without a default route.
+func validateNetworkDevice(d infrav1.NetworkDeviceSpec) error {
+	if d.VLAN != nil && (*d.VLAN < 1 || *d.VLAN > 4094) {
+		return errors.Errorf("vlan %d is not between 1 and 4094", *d.VLAN)
+	}
+	for _, gw := range []struct {
+		name  string
+		value string
+		ipv4  bool
+	}{
+		{name: "gateway4", value: d.Gateway4, ipv4: true},
+		{name: "gateway6", value: d.Gateway6, ipv4: false},
+	} {
+		if gw.value == "" {
+			continue
+		}
+		ip := net.ParseIP(gw.value)
+		if ip == nil || (ip.To4() != nil) != gw.ipv4 {
+			return errors.Errorf("%s %q is not a valid IP address", gw.name, gw.value)
+		}
+
+		var subnets []*net.IPNet
+		for _, addr := range d.IPAddrs {
+			_, subnet, err := net.ParseCIDR(addr)
+			if err != nil || (subnet.IP.To4() != nil) != gw.ipv4 {
+				continue
+			}
+			subnets = append(subnets, subnet)
+		}
+		if len(subnets) == 0 {
+			continue
+		}
+		reachable := false
+		for _, subnet := range subnets {
+			if subnet.Contains(ip) {
+				reachable = true
+				break
+			}
+		}
+		if !reachable {
+			return errors.Errorf("%s %q is not in the subnet of any of the device's addresses", gw.name, gw.value)
+		}
+	}
+	return nil
+}
+
+// GetMachineMetadata returns the cloud-init metadata as a base-64 encoded
+// string for a given VSphereMachine. If the machine's network spec has a
+// domain, the local hostname is rendered as a fully-qualified domain name.
+func GetMachineMetadata(hostname string, machine infrav1.VSphereVM, networkStatus ...infrav1.NetworkStatus) ([]byte, error) {
+	return GetMachineMetadataWithTemplate(metadataFormat, hostname, machine, networkStatus...)
+}
+
+// metadataFuncs are the functions available to metadata templates.
+var metadataFuncs = template.FuncMap{
+	"nameservers": func(spec infrav1.NetworkDeviceSpec) bool {
+		return len(spec.Nameservers) > 0 || len(spec.SearchDomains) > 0
+	},
+	"ipv4": hasIPv4,
+	// vlans returns true if any of the devices has a VLAN sub-interface.
+	"vlans": func(devices []infrav1.NetworkDeviceSpec) bool {
+		for i := range devices {
+			if devices[i].VLAN != nil {
+				return true
+			}
+		}
+		return false
+	},
+	// quote returns s as a double-quoted YAML string.
+	"quote": func(s string) (string, error) {
+		data, err := json.Marshal(s)
+		return string(data), err
+	},
+}
+
+// GetMachineMetadataWithTemplate is like GetMachineMetadata, but renders
+// the given template instead of the default one. The template is executed
+// with a MachineMetadata and may use the same functions as the default
+// template.
+func GetMachineMetadataWithTemplate(tmpl string, hostname string, machine infrav1.VSphereVM, networkStatus ...infrav1.NetworkStatus) ([]byte, error) {
+	metadata, err := BuildMachineMetadata(hostname, machine, networkStatus...)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl, err := template.New("t").Funcs(metadataFuncs).Parse(tmpl)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing cloud init metadata template")
+	}
+	buf := &bytes.Buffer{}
+	if err := tpl.Execute(buf, metadata); err != nil {
 		return nil, errors.Wrapf(
 			err,
 			"error getting cloud init metadata for machine %s/%s/%s",
@@ -209,37 +818,150 @@ const (
 	// to convert a providerID into a UUID string.
 	ProviderIDPattern = `(?i)^` + ProviderIDPrefix + `([a-f\d]{8}-[a-f\d]{4}-[a-f\d]{4}-[a-f\d]{4}-[a-f\d]{12})$`
 
+	// InstanceUUIDProviderIDPrefix is the string data prefixed to an
+	// instance UUID in order to build a provider ID that records the UUID
+	// is an instance UUID rather than a BIOS UUID.
+	InstanceUUIDProviderIDPrefix = ProviderIDPrefix + "instance/"
+
+	// InstanceUUIDProviderIDPattern is a regex pattern and is used by
+	// ParseProviderIDKind to recognize a providerID built from an instance UUID.
+	InstanceUUIDProviderIDPattern = `(?i)^` + InstanceUUIDProviderIDPrefix + `([a-f\d]{8}-[a-f\d]{4}-[a-f\d]{4}-[a-f\d]{4}-[a-f\d]{12})$`
+
 	// UUIDPattern is a regex pattern and is used by ConvertUUIDToProviderID
 	// to convert a UUID into a providerID string.
 	UUIDPattern = `(?i)^[a-f\d]{8}-[a-f\d]{4}-[a-f\d]{4}-[a-f\d]{4}-[a-f\d]{12}$`
 )
 
+// The patterns are compiled once as they are matched for every node and
+// machine that is reconciled.
+var (
+	providerIDRegexp             = regexp.MustCompile(ProviderIDPattern)
+	instanceUUIDProviderIDRegexp = regexp.MustCompile(InstanceUUIDProviderIDPattern)
+	uuidRegexp                   = regexp.MustCompile(UUIDPattern)
+)
+
+var (
+	// ErrProviderIDEmpty is returned by ParseProviderID when the provider ID
+	// is nil, empty, or only whitespace, such as before a node has been
+	// assigned one.
+	ErrProviderIDEmpty = errors.New("provider ID is empty")
+
+	// ErrProviderIDMalformed is returned, wrapped, by ParseProviderID when
+	// the provider ID does not adhere to ProviderIDPattern.
+	ErrProviderIDMalformed = errors.New("provider ID is malformed")
+
+	// ErrProviderIDInstanceUUID is returned, wrapped, by ParseProviderID when
+	// the provider ID was built from an instance UUID, as it does not hold a
+	// BIOS UUID. Use ParseProviderIDKind to parse such provider IDs.
+	ErrProviderIDInstanceUUID = errors.New("provider ID holds an instance UUID")
+)
+
+// ParseProviderID transforms a provider ID into a UUID string as
+// ConvertProviderIDToUUID does, but reports why no UUID was found.
+// ErrProviderIDEmpty is returned if providerID is nil or empty, an error
+// wrapping ErrProviderIDInstanceUUID if it was built from an instance UUID,
+// and an error wrapping ErrProviderIDMalformed if it is otherwise invalid.
+func ParseProviderID(providerID *string) (string, error) {
+	if providerID == nil {
+		return "", ErrProviderIDEmpty
+	}
+	trimmed := trimProviderIDBraces(strings.TrimSpace(*providerID))
+	if trimmed == "" {
+		return "", ErrProviderIDEmpty
+	}
+	if instanceUUIDProviderIDRegexp.MatchString(trimmed) {
+		return "", errors.Wrapf(ErrProviderIDInstanceUUID, "provider ID %q", *providerID)
+	}
+	matches := providerIDRegexp.FindStringSubmatch(trimmed)
+	if len(matches) < 2 {
+		return "", errors.Wrapf(ErrProviderIDMalformed, "invalid provider ID %q", *providerID)
+	}
+	return strings.ToLower(matches[1]), nil
+}
+
 // ConvertProviderIDToUUID transforms a provider ID into a UUID string.
-// If providerID is nil, empty, or invalid, then an empty string is returned.
+// If providerID is nil, empty, or invalid, then an empty string is returned;
+// use ParseProviderID to tell these cases apart.
 // A valid providerID should adhere to the format specified by
-// ProviderIDPattern.
+// ProviderIDPattern once surrounding whitespace, and any braces around the
+// UUID, are removed. The UUID is returned in lowercase, which is how vSphere
+// reports UUIDs.
 func ConvertProviderIDToUUID(providerID *string) string {
-	if providerID == nil || *providerID == "" {
-		return ""
-	}
-	pattern := regexp.MustCompile(ProviderIDPattern)
-	matches := pattern.FindStringSubmatch(*providerID)
-	if len(matches) < 2 {
-		return ""
+	uuid, _ := ParseProviderID(providerID)
+	return uuid
+}
+
+// ConvertProviderIDsToUUIDs transforms provider IDs into UUID strings as
+// ConvertProviderIDToUUID does. The UUID at each index corresponds to the
+// provider ID at the same index, and is empty if the provider ID is nil,
+// empty, or invalid.
+func ConvertProviderIDsToUUIDs(providerIDs []*string) []string {
+	uuids := make([]string, len(providerIDs))
+	for i, providerID := range providerIDs {
+		uuids[i] = ConvertProviderIDToUUID(providerID)
 	}
-	return matches[1]
+	return uuids
 }
 
 // ConvertUUIDToProviderID transforms a UUID string into a provider ID.
 // If the supplied UUID is empty or invalid then an empty string is returned.
-// A valid UUID should adhere to the format specified by UUIDPattern.
+// A valid UUID should adhere to the format specified by UUIDPattern once
+// any surrounding braces are removed.
 func ConvertUUIDToProviderID(uuid string) string {
+	uuid = trimBraces(uuid)
 	if uuid == "" {
 		return ""
 	}
-	pattern := regexp.MustCompile(UUIDPattern)
-	if !pattern.MatchString(uuid) {
+	if !uuidRegexp.MatchString(uuid) {
 		return ""
 	}
 	return ProviderIDPrefix + uuid
 }
+
+// ConvertInstanceUUIDToProviderID transforms an instance UUID string into a
+// provider ID that is distinguishable from one built from a BIOS UUID.
+// If the supplied UUID is empty or invalid then an empty string is returned.
+// A valid UUID should adhere to the format specified by UUIDPattern once
+// any surrounding braces are removed.
+func ConvertInstanceUUIDToProviderID(uuid string) string {
+	uuid = trimBraces(uuid)
+	if uuid == "" {
+		return ""
+	}
+	if !uuidRegexp.MatchString(uuid) {
+		return ""
+	}
+	return InstanceUUIDProviderIDPrefix + uuid
+}
+
+// trimBraces removes a pair of braces surrounding a UUID, as some vCenter
+// builds report instance UUIDs as "{...}". Unpaired braces are left in place
+// so the UUID is still rejected as malformed.
+func trimBraces(uuid string) string {
+	if len(uuid) >= 2 && strings.HasPrefix(uuid, "{") && strings.HasSuffix(uuid, "}") {
+		return uuid[1 : len(uuid)-1]
+	}
+	return uuid
+}
+
+// trimProviderIDBraces removes a pair of braces surrounding the UUID at the
+// end of a provider ID.
+func trimProviderIDBraces(providerID string) string {
+	i := strings.LastIndex(providerID, "/") + 1
+	return providerID[:i] + trimBraces(providerID[i:])
+}
+
+// ParseProviderIDKind returns the UUID encoded in a provider ID and whether
+// it is an instance UUID, as built by ConvertInstanceUUIDToProviderID, or a
+// BIOS UUID, as built by ConvertUUIDToProviderID. The errors are those
+// returned by ParseProviderID, except that instance UUIDs are parsed.
+func ParseProviderIDKind(providerID *string) (string, bool, error) {
+	if providerID != nil {
+		trimmed := trimProviderIDBraces(strings.TrimSpace(*providerID))
+		if matches := instanceUUIDProviderIDRegexp.FindStringSubmatch(trimmed); len(matches) == 2 {
+			return strings.ToLower(matches[1]), true, nil
+		}
+	}
+	uuid, err := ParseProviderID(providerID)
+	return uuid, false, err
+}
@@ -17,15 +17,239 @@ limitations under the License.
 package util_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
 	"testing"
 
 	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	"sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/constants"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/util"
 )
 
+func newMachine(namespace, name, clusterName string, controlPlaneLabel bool, ownerKind string) *clusterv1.Machine {
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    map[string]string{clusterv1.ClusterLabelName: clusterName},
+		},
+	}
+	if controlPlaneLabel {
+		machine.Labels[clusterv1.MachineControlPlaneLabelName] = ""
+	}
+	switch ownerKind {
+	case "KubeadmControlPlane":
+		machine.OwnerReferences = []metav1.OwnerReference{
+			{APIVersion: "controlplane.cluster.x-k8s.io/v1alpha3", Kind: ownerKind, Name: "kcp"},
+		}
+	case "MachineSet":
+		machine.OwnerReferences = []metav1.OwnerReference{
+			{APIVersion: "cluster.x-k8s.io/v1alpha3", Kind: ownerKind, Name: "ms"},
+		}
+	}
+	return machine
+}
+
+func TestGetMachinesInClusterByRole(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(clusterv1.AddToScheme(scheme)).To(gomega.Succeed())
+	controllerClient := fake.NewFakeClientWithScheme(scheme,
+		newMachine("default", "cp-label", "my-cluster", true, ""),
+		newMachine("default", "cp-owner", "my-cluster", false, "KubeadmControlPlane"),
+		newMachine("default", "cp-label-and-owner", "my-cluster", true, "KubeadmControlPlane"),
+		newMachine("default", "worker-0", "my-cluster", false, "MachineSet"),
+		newMachine("default", "worker-1", "my-cluster", false, ""),
+		newMachine("default", "other-cp", "other-cluster", true, ""),
+		newMachine("default", "other-worker", "other-cluster", false, "MachineSet"),
+		newMachine("other", "other-namespace-worker", "my-cluster", false, "MachineSet"),
+	)
+
+	names := func(machines []*clusterv1.Machine) []string {
+		result := make([]string, len(machines))
+		for i := range machines {
+			result[i] = machines[i].Name
+		}
+		return result
+	}
+
+	controlPlaneMachines, err := util.GetControlPlaneMachinesInCluster(context.Background(), controllerClient, "default", "my-cluster")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(names(controlPlaneMachines)).To(gomega.ConsistOf("cp-label", "cp-owner", "cp-label-and-owner"))
+
+	workerMachines, err := util.GetWorkerMachinesInCluster(context.Background(), controllerClient, "default", "my-cluster")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(names(workerMachines)).To(gomega.ConsistOf("worker-0", "worker-1"))
+}
+
+// duplicatingClient is a client that returns every VSphereMachine it lists
+// twice.
+type duplicatingClient struct {
+	client.Client
+}
+
+func (c duplicatingClient) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	if err := c.Client.List(ctx, list, opts...); err != nil {
+		return err
+	}
+	if machineList, ok := list.(*v1alpha3.VSphereMachineList); ok {
+		machineList.Items = append(machineList.Items, machineList.Items...)
+	}
+	return nil
+}
+
+func TestGetVSphereMachinesInClusterByName(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	newVSphereMachine := func(name, clusterName string) *v1alpha3.VSphereMachine {
+		return &v1alpha3.VSphereMachine{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      name,
+				Labels:    map[string]string{clusterv1.ClusterLabelName: clusterName},
+			},
+		}
+	}
+
+	scheme := runtime.NewScheme()
+	g.Expect(v1alpha3.AddToScheme(scheme)).To(gomega.Succeed())
+	controllerClient := fake.NewFakeClientWithScheme(scheme,
+		newVSphereMachine("machine-0", "my-cluster"),
+		newVSphereMachine("machine-1", "my-cluster"),
+		newVSphereMachine("other-machine", "other-cluster"),
+	)
+
+	machines, err := util.GetVSphereMachinesInClusterByName(context.Background(), controllerClient, "default", "my-cluster")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(machines).To(gomega.HaveLen(2))
+	for _, name := range []string{"machine-0", "machine-1"} {
+		g.Expect(machines).To(gomega.HaveKey(name))
+		g.Expect(machines[name].Name).To(gomega.Equal(name))
+	}
+
+	_, err = util.GetVSphereMachinesInClusterByName(context.Background(), duplicatingClient{controllerClient}, "default", "my-cluster")
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("duplicate VSphereMachine")))
+}
+
+// pagingClient is a client that honors the limit and continue list options
+// for Machines, which the fake client ignores.
+type pagingClient struct {
+	client.Client
+	lists int
+}
+
+func (c *pagingClient) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	if err := c.Client.List(ctx, list, opts...); err != nil {
+		return err
+	}
+	c.lists++
+	machineList, ok := list.(*clusterv1.MachineList)
+	if !ok {
+		return nil
+	}
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	offset := 0
+	if listOpts.Continue != "" {
+		var err error
+		if offset, err = strconv.Atoi(listOpts.Continue); err != nil {
+			return err
+		}
+	}
+	items := machineList.Items[offset:]
+	machineList.Continue = ""
+	if listOpts.Limit > 0 && int64(len(items)) > listOpts.Limit {
+		items = items[:listOpts.Limit]
+		machineList.Continue = strconv.Itoa(offset + len(items))
+	}
+	machineList.Items = items
+	return nil
+}
+
+func TestForEachMachinesPageInCluster(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clusterv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	objs := []runtime.Object{newMachine("default", "other-machine", "other-cluster", false, "")}
+	for i := 0; i < 7; i++ {
+		objs = append(objs, newMachine("default", fmt.Sprintf("machine-%d", i), "my-cluster", false, ""))
+	}
+
+	testCases := []struct {
+		name          string
+		limit         int64
+		expectedPages int
+	}{
+		{
+			name:          "unpaged",
+			limit:         0,
+			expectedPages: 1,
+		},
+		{
+			name:          "uneven pages",
+			limit:         3,
+			expectedPages: 3,
+		},
+		{
+			name:          "even pages",
+			limit:         7,
+			expectedPages: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+			controllerClient := &pagingClient{Client: fake.NewFakeClientWithScheme(scheme, objs...)}
+
+			visited := map[string]int{}
+			pages := 0
+			err := util.ForEachMachinesPageInCluster(context.Background(), controllerClient, "default", "my-cluster", tc.limit,
+				func(machines []*clusterv1.Machine) error {
+					pages++
+					if tc.limit > 0 {
+						g.Expect(int64(len(machines))).To(gomega.BeNumerically("<=", tc.limit))
+					}
+					for _, machine := range machines {
+						visited[machine.Name]++
+					}
+					return nil
+				})
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(pages).To(gomega.Equal(tc.expectedPages))
+			g.Expect(controllerClient.lists).To(gomega.Equal(tc.expectedPages))
+			g.Expect(visited).To(gomega.HaveLen(7))
+			for name, count := range visited {
+				g.Expect(count).To(gomega.Equal(1), "machine %s visited %d times", name, count)
+			}
+		})
+	}
+
+	t.Run("callback error stops listing", func(t *testing.T) {
+		g := gomega.NewGomegaWithT(t)
+		controllerClient := &pagingClient{Client: fake.NewFakeClientWithScheme(scheme, objs...)}
+		err := util.ForEachMachinesPageInCluster(context.Background(), controllerClient, "default", "my-cluster", 3,
+			func([]*clusterv1.Machine) error {
+				return errors.New("stop")
+			})
+		g.Expect(err).To(gomega.MatchError("stop"))
+		g.Expect(controllerClient.lists).To(gomega.Equal(1))
+	})
+}
+
 func Test_GetMachinePreferredIPAddress(t *testing.T) {
 	testCases := []struct {
 		name        string
@@ -165,6 +389,118 @@ func Test_GetMachinePreferredIPAddress(t *testing.T) {
 			ipAddr:      "fdf3:35b5:9dad:6e09::0001",
 			expectedErr: nil,
 		},
+		{
+			name: "multiple IPv4 addresses, multiple preferred v4 CIDRs",
+			machine: &v1alpha3.VSphereMachine{
+				Spec: v1alpha3.VSphereMachineSpec{
+					VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+						Network: v1alpha3.NetworkSpec{
+							PreferredAPIServerCIDR: "10.0.0.0/8, 192.168.0.0/16",
+						},
+					},
+				},
+				Status: v1alpha3.VSphereMachineStatus{
+					Addresses: []clusterv1.MachineAddress{
+						{
+							Type:    clusterv1.MachineExternalIP,
+							Address: "172.17.0.1",
+						},
+						{
+							Type:    clusterv1.MachineExternalIP,
+							Address: "192.168.0.1",
+						},
+						{
+							Type:    clusterv1.MachineExternalIP,
+							Address: "10.0.0.1",
+						},
+					},
+				},
+			},
+			ipAddr:      "192.168.0.1",
+			expectedErr: nil,
+		},
+		{
+			name: "multiple IPv6 addresses, multiple preferred v6 CIDRs",
+			machine: &v1alpha3.VSphereMachine{
+				Spec: v1alpha3.VSphereMachineSpec{
+					VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+						Network: v1alpha3.NetworkSpec{
+							PreferredAPIServerCIDR: "fd00:1::/64,fdf3:35b5:9dad:6e09::/64",
+						},
+					},
+				},
+				Status: v1alpha3.VSphereMachineStatus{
+					Addresses: []clusterv1.MachineAddress{
+						{
+							Type:    clusterv1.MachineExternalIP,
+							Address: "fe80::1",
+						},
+						{
+							Type:    clusterv1.MachineExternalIP,
+							Address: "fdf3:35b5:9dad:6e09::0001",
+						},
+					},
+				},
+			},
+			ipAddr:      "fdf3:35b5:9dad:6e09::0001",
+			expectedErr: nil,
+		},
+		{
+			name: "multiple IPv4 and IPv6 addresses, mixed preferred CIDRs",
+			machine: &v1alpha3.VSphereMachine{
+				Spec: v1alpha3.VSphereMachineSpec{
+					VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+						Network: v1alpha3.NetworkSpec{
+							PreferredAPIServerCIDR: "10.0.0.0/8,fdf3:35b5:9dad:6e09::/64",
+						},
+					},
+				},
+				Status: v1alpha3.VSphereMachineStatus{
+					Addresses: []clusterv1.MachineAddress{
+						{
+							Type:    clusterv1.MachineExternalIP,
+							Address: "192.168.0.1",
+						},
+						{
+							Type:    clusterv1.MachineExternalIP,
+							Address: "fdf3:35b5:9dad:6e09::0001",
+						},
+						{
+							Type:    clusterv1.MachineExternalIP,
+							Address: "10.0.0.1",
+						},
+					},
+				},
+			},
+			ipAddr:      "fdf3:35b5:9dad:6e09::0001",
+			expectedErr: nil,
+		},
+		{
+			name: "no addresses found with mixed preferred CIDRs",
+			machine: &v1alpha3.VSphereMachine{
+				Spec: v1alpha3.VSphereMachineSpec{
+					VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+						Network: v1alpha3.NetworkSpec{
+							PreferredAPIServerCIDR: "10.0.0.0/8,fdf3:35b5:9dad:6e09::/64",
+						},
+					},
+				},
+				Status: v1alpha3.VSphereMachineStatus{
+					Addresses: []clusterv1.MachineAddress{
+						{
+							Type:    clusterv1.MachineExternalIP,
+							Address: "192.168.0.1",
+						},
+						{
+							Type:    clusterv1.MachineExternalIP,
+							Address: "fe80::1",
+						},
+					},
+				},
+			},
+			ipAddr:      "",
+			expectedErr: util.ErrNoMachineIPAddr,
+		},
 		{
 			name: "no addresses found",
 			machine: &v1alpha3.VSphereMachine{
@@ -226,82 +562,304 @@ func Test_GetMachinePreferredIPAddress(t *testing.T) {
 	}
 }
 
-func Test_GetMachineMetadata(t *testing.T) {
+func Test_GetMachinePreferredIPAddress_InvalidCIDR(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	machine := &v1alpha3.VSphereMachine{
+		Spec: v1alpha3.VSphereMachineSpec{
+			VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+				Network: v1alpha3.NetworkSpec{
+					PreferredAPIServerCIDR: "10.0.0.0/8, 192.168.0.0/33",
+				},
+			},
+		},
+	}
+
+	_, err := util.GetMachinePreferredIPAddress(machine)
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(err.Error()).To(gomega.ContainSubstring(`"192.168.0.0/33"`))
+	var parseErr *net.ParseError
+	g.Expect(errors.As(err, &parseErr)).To(gomega.BeTrue())
+	g.Expect(parseErr.Text).To(gomega.Equal("192.168.0.0/33"))
+}
+
+func Test_GetMachinePreferredIPAddressOfType(t *testing.T) {
 	testCases := []struct {
-		name     string
-		machine  *v1alpha3.VSphereVM
-		expected string
+		name        string
+		preference  util.AddressTypePreference
+		machine     *v1alpha3.VSphereMachine
+		ipAddr      string
+		expectedErr error
 	}{
 		{
-			name: "dhcp4",
-			machine: &v1alpha3.VSphereVM{
-				Spec: v1alpha3.VSphereVMSpec{
-					VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
-						Network: v1alpha3.NetworkSpec{
-							Devices: []v1alpha3.NetworkDeviceSpec{
-								{
-									NetworkName: "network1",
-									MACAddr:     "00:00:00:00:00",
-									DHCP4:       true,
-								},
-							},
+			name:       "only internal addresses, external preference",
+			preference: util.AddressTypePreferenceExternal,
+			machine: &v1alpha3.VSphereMachine{
+				Status: v1alpha3.VSphereMachineStatus{
+					Addresses: []clusterv1.MachineAddress{
+						{
+							Type:    clusterv1.MachineInternalIP,
+							Address: "10.0.0.1",
+						},
+						{
+							Type:    clusterv1.MachineInternalIP,
+							Address: "10.0.0.2",
 						},
 					},
 				},
 			},
-			expected: `
-instance-id: "test-vm"
-local-hostname: "test-vm"
-wait-on-network:
-  ipv4: true
-  ipv6: false
-network:
-  version: 2
-  ethernets:
-    id0:
-      match:
-        macaddress: "00:00:00:00:00"
-      set-name: "eth0"
-      wakeonlan: true
-      dhcp4: true
-      dhcp6: false
-`,
+			ipAddr:      "",
+			expectedErr: util.ErrNoMachineIPAddr,
 		},
 		{
-			name: "dhcp4+deviceName",
-			machine: &v1alpha3.VSphereVM{
-				Spec: v1alpha3.VSphereVMSpec{
-					VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
-						Network: v1alpha3.NetworkSpec{
-							Devices: []v1alpha3.NetworkDeviceSpec{
-								{
-									NetworkName: "network1",
-									MACAddr:     "00:00:00:00:00",
-									DHCP4:       true,
-									DeviceName:  "ens192",
-								},
-							},
+			name:       "only internal addresses, internal preference",
+			preference: util.AddressTypePreferenceInternal,
+			machine: &v1alpha3.VSphereMachine{
+				Status: v1alpha3.VSphereMachineStatus{
+					Addresses: []clusterv1.MachineAddress{
+						{
+							Type:    clusterv1.MachineInternalIP,
+							Address: "10.0.0.1",
+						},
+						{
+							Type:    clusterv1.MachineInternalIP,
+							Address: "10.0.0.2",
 						},
 					},
 				},
 			},
-			expected: `
-instance-id: "test-vm"
-local-hostname: "test-vm"
-wait-on-network:
-  ipv4: true
-  ipv6: false
-network:
-  version: 2
-  ethernets:
-    id0:
-      match:
-        macaddress: "00:00:00:00:00"
-      set-name: "ens192"
-      wakeonlan: true
-      dhcp4: true
-      dhcp6: false
-`,
+			ipAddr:      "10.0.0.1",
+			expectedErr: nil,
+		},
+		{
+			name:       "only internal addresses, any preference",
+			preference: util.AddressTypePreferenceAny,
+			machine: &v1alpha3.VSphereMachine{
+				Status: v1alpha3.VSphereMachineStatus{
+					Addresses: []clusterv1.MachineAddress{
+						{
+							Type:    clusterv1.MachineInternalIP,
+							Address: "10.0.0.1",
+						},
+						{
+							Type:    clusterv1.MachineInternalIP,
+							Address: "10.0.0.2",
+						},
+					},
+				},
+			},
+			ipAddr:      "10.0.0.1",
+			expectedErr: nil,
+		},
+		{
+			name:       "internal and external addresses, external preference",
+			preference: util.AddressTypePreferenceExternal,
+			machine: &v1alpha3.VSphereMachine{
+				Status: v1alpha3.VSphereMachineStatus{
+					Addresses: []clusterv1.MachineAddress{
+						{
+							Type:    clusterv1.MachineInternalIP,
+							Address: "10.0.0.1",
+						},
+						{
+							Type:    clusterv1.MachineExternalIP,
+							Address: "192.168.0.1",
+						},
+					},
+				},
+			},
+			ipAddr:      "192.168.0.1",
+			expectedErr: nil,
+		},
+		{
+			name:       "internal and external addresses, internal preference",
+			preference: util.AddressTypePreferenceInternal,
+			machine: &v1alpha3.VSphereMachine{
+				Status: v1alpha3.VSphereMachineStatus{
+					Addresses: []clusterv1.MachineAddress{
+						{
+							Type:    clusterv1.MachineInternalIP,
+							Address: "10.0.0.1",
+						},
+						{
+							Type:    clusterv1.MachineExternalIP,
+							Address: "192.168.0.1",
+						},
+					},
+				},
+			},
+			ipAddr:      "10.0.0.1",
+			expectedErr: nil,
+		},
+		{
+			name:       "internal and external addresses, any preference",
+			preference: util.AddressTypePreferenceAny,
+			machine: &v1alpha3.VSphereMachine{
+				Status: v1alpha3.VSphereMachineStatus{
+					Addresses: []clusterv1.MachineAddress{
+						{
+							Type:    clusterv1.MachineInternalIP,
+							Address: "10.0.0.1",
+						},
+						{
+							Type:    clusterv1.MachineExternalIP,
+							Address: "192.168.0.1",
+						},
+					},
+				},
+			},
+			ipAddr:      "10.0.0.1",
+			expectedErr: nil,
+		},
+		{
+			name:       "only external addresses, internal preference",
+			preference: util.AddressTypePreferenceInternal,
+			machine: &v1alpha3.VSphereMachine{
+				Status: v1alpha3.VSphereMachineStatus{
+					Addresses: []clusterv1.MachineAddress{
+						{
+							Type:    clusterv1.MachineExternalIP,
+							Address: "192.168.0.1",
+						},
+					},
+				},
+			},
+			ipAddr:      "",
+			expectedErr: util.ErrNoMachineIPAddr,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ipAddr, err := util.GetMachinePreferredIPAddressOfType(tc.machine, tc.preference)
+			if err != tc.expectedErr {
+				t.Logf("expected err: %q", tc.expectedErr)
+				t.Logf("actual err: %q", err)
+				t.Errorf("unexpected error")
+			}
+
+			if ipAddr != tc.ipAddr {
+				t.Logf("expected IP addr: %q", tc.ipAddr)
+				t.Logf("actual IP addr: %q", ipAddr)
+				t.Error("unexpected IP addr from machine context")
+			}
+		})
+	}
+}
+
+func Test_GetMachineMetadata(t *testing.T) {
+	testCases := []struct {
+		name     string
+		machine  *v1alpha3.VSphereVM
+		expected string
+	}{
+		{
+			name: "dhcp4",
+			machine: &v1alpha3.VSphereVM{
+				Spec: v1alpha3.VSphereVMSpec{
+					VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+						Network: v1alpha3.NetworkSpec{
+							Devices: []v1alpha3.NetworkDeviceSpec{
+								{
+									NetworkName: "network1",
+									MACAddr:     "00:00:00:00:00",
+									DHCP4:       true,
+								},
+							},
+						},
+					},
+				},
+			},
+			expected: `
+instance-id: "dhcp4"
+local-hostname: "test-vm"
+wait-on-network:
+  ipv4: true
+  ipv6: false
+network:
+  version: 2
+  ethernets:
+    id0:
+      match:
+        macaddress: "00:00:00:00:00"
+      set-name: "eth0"
+      wakeonlan: true
+      dhcp4: true
+      dhcp6: false
+`,
+		},
+		{
+			name: "dhcp4+domain",
+			machine: &v1alpha3.VSphereVM{
+				Spec: v1alpha3.VSphereVMSpec{
+					VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+						Network: v1alpha3.NetworkSpec{
+							Domain: "site1.example.com",
+							Devices: []v1alpha3.NetworkDeviceSpec{
+								{
+									NetworkName: "network1",
+									MACAddr:     "00:00:00:00:00",
+									DHCP4:       true,
+								},
+							},
+						},
+					},
+				},
+			},
+			expected: `
+instance-id: "dhcp4+domain"
+local-hostname: "test-vm.site1.example.com"
+wait-on-network:
+  ipv4: true
+  ipv6: false
+network:
+  version: 2
+  ethernets:
+    id0:
+      match:
+        macaddress: "00:00:00:00:00"
+      set-name: "eth0"
+      wakeonlan: true
+      dhcp4: true
+      dhcp6: false
+`,
+		},
+		{
+			name: "dhcp4+deviceName",
+			machine: &v1alpha3.VSphereVM{
+				Spec: v1alpha3.VSphereVMSpec{
+					VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+						Network: v1alpha3.NetworkSpec{
+							Devices: []v1alpha3.NetworkDeviceSpec{
+								{
+									NetworkName: "network1",
+									MACAddr:     "00:00:00:00:00",
+									DHCP4:       true,
+									DeviceName:  "ens192",
+								},
+							},
+						},
+					},
+				},
+			},
+			expected: `
+instance-id: "dhcp4+deviceName"
+local-hostname: "test-vm"
+wait-on-network:
+  ipv4: true
+  ipv6: false
+network:
+  version: 2
+  ethernets:
+    id0:
+      match:
+        macaddress: "00:00:00:00:00"
+      set-name: "ens192"
+      wakeonlan: true
+      dhcp4: true
+      dhcp6: false
+`,
 		},
 		{
 			name: "dhcp6",
@@ -321,7 +879,7 @@ network:
 				},
 			},
 			expected: `
-instance-id: "test-vm"
+instance-id: "dhcp6"
 local-hostname: "test-vm"
 wait-on-network:
   ipv4: false
@@ -334,7 +892,6 @@ network:
         macaddress: "00:00:00:00:00"
       set-name: "eth0"
       wakeonlan: true
-      dhcp4: false
       dhcp6: true
 `,
 		},
@@ -357,7 +914,7 @@ network:
 				},
 			},
 			expected: `
-instance-id: "test-vm"
+instance-id: "dhcp4+dhcp6"
 local-hostname: "test-vm"
 wait-on-network:
   ipv4: true
@@ -394,7 +951,7 @@ network:
 				},
 			},
 			expected: `
-instance-id: "test-vm"
+instance-id: "static4+dhcp6"
 local-hostname: "test-vm"
 wait-on-network:
   ipv4: true
@@ -441,7 +998,7 @@ network:
 				},
 			},
 			expected: `
-instance-id: "test-vm"
+instance-id: "static4+dhcp6+static-routes"
 local-hostname: "test-vm"
 wait-on-network:
   ipv4: true
@@ -496,7 +1053,7 @@ network:
 				},
 			},
 			expected: `
-instance-id: "test-vm"
+instance-id: "2nets"
 local-hostname: "test-vm"
 wait-on-network:
   ipv4: true
@@ -520,7 +1077,6 @@ network:
         macaddress: "00:00:00:00:01"
       set-name: "eth1"
       wakeonlan: true
-      dhcp4: false
       dhcp6: true
       mtu: 100
 `,
@@ -553,7 +1109,7 @@ network:
 				},
 			},
 			expected: `
-instance-id: "test-vm"
+instance-id: "2nets-static+dhcp"
 local-hostname: "test-vm"
 wait-on-network:
   ipv4: true
@@ -579,7 +1135,6 @@ network:
         macaddress: "00:00:00:00:01"
       set-name: "eth1"
       wakeonlan: true
-      dhcp4: false
       dhcp6: true
       nameservers:
         search:
@@ -605,49 +1160,1088 @@ network:
 	}
 }
 
-func TestConvertProviderIDToUUID(t *testing.T) {
-	g := gomega.NewGomegaWithT(t)
-
-	testCases := []struct {
-		name         string
-		providerID   *string
-		expectedUUID string
-	}{
-		{
-			name:         "nil providerID",
-			providerID:   nil,
-			expectedUUID: "",
-		},
-		{
-			name:         "empty providerID",
-			providerID:   toStringPtr(""),
-			expectedUUID: "",
-		},
-		{
-			name:         "invalid providerID",
-			providerID:   toStringPtr("1234"),
-			expectedUUID: "",
-		},
-		{
-			name:         "missing prefix",
-			providerID:   toStringPtr("12345678-1234-1234-1234-123456789abc"),
-			expectedUUID: "",
+func Test_GetMachineMetadata_PartialNetworkStatus(t *testing.T) {
+	machine := v1alpha3.VSphereVM{
+		Spec: v1alpha3.VSphereVMSpec{
+			VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+				Network: v1alpha3.NetworkSpec{
+					Devices: []v1alpha3.NetworkDeviceSpec{
+						{
+							NetworkName: "network1",
+							DHCP4:       true,
+						},
+						{
+							NetworkName: "network2",
+							DHCP4:       true,
+						},
+						{
+							NetworkName: "network3",
+							DHCP4:       true,
+						},
+					},
+				},
+			},
 		},
+	}
+	networkStatus := []v1alpha3.NetworkStatus{
 		{
-			name:         "valid providerID",
-			providerID:   toStringPtr("vsphere://12345678-1234-1234-1234-123456789abc"),
-			expectedUUID: "12345678-1234-1234-1234-123456789abc",
+			MACAddr: "00:00:00:00:00",
 		},
 		{
-			name:         "mixed case",
-			providerID:   toStringPtr("vsphere://12345678-1234-1234-1234-123456789AbC"),
-			expectedUUID: "12345678-1234-1234-1234-123456789AbC",
+			MACAddr: "00:00:00:00:01",
+		},
+	}
+	expected := `
+instance-id: "test-vm"
+local-hostname: "test-vm"
+wait-on-network:
+  ipv4: true
+  ipv6: false
+network:
+  version: 2
+  ethernets:
+    id0:
+      match:
+        macaddress: "00:00:00:00:00"
+      set-name: "eth0"
+      wakeonlan: true
+      dhcp4: true
+      dhcp6: false
+    id1:
+      match:
+        macaddress: "00:00:00:00:01"
+      set-name: "eth1"
+      wakeonlan: true
+      dhcp4: true
+      dhcp6: false
+    id2:
+      match:
+        macaddress: ""
+      set-name: "eth2"
+      wakeonlan: true
+      dhcp4: true
+      dhcp6: false
+`
+
+	actVal, err := util.GetMachineMetadata("test-vm", machine, networkStatus...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(actVal) != expected {
+		t.Logf("actual metadata value: %s", actVal)
+		t.Logf("expected metadata value: %s", expected)
+		t.Error("unexpected metadata value")
+	}
+}
+
+func Test_BuildMachineMetadata(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	machine := v1alpha3.VSphereVM{
+		Spec: v1alpha3.VSphereVMSpec{
+			VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+				Network: v1alpha3.NetworkSpec{
+					Domain: "example.com.",
+					Devices: []v1alpha3.NetworkDeviceSpec{
+						{
+							NetworkName: "network1",
+							IPAddrs:     []string{"192.168.4.21"},
+							Gateway4:    "192.168.4.1",
+						},
+						{
+							NetworkName: "network2",
+							MACAddr:     "00:00:00:00:ff",
+							DHCP6:       true,
+						},
+					},
+					Routes: []v1alpha3.NetworkRouteSpec{
+						{
+							To:     "192.168.5.1/24",
+							Via:    "192.168.4.254",
+							Metric: 3,
+						},
+					},
+				},
+			},
+		},
+	}
+	networkStatus := []v1alpha3.NetworkStatus{
+		{
+			MACAddr: "00:00:00:00:00",
+		},
+	}
+
+	metadata, err := util.BuildMachineMetadata("test-vm", machine, networkStatus...)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(metadata.Hostname).To(gomega.Equal("test-vm"))
+	g.Expect(metadata.Domain).To(gomega.Equal("example.com"))
+	g.Expect(metadata.WaitForIPv4).To(gomega.BeTrue())
+	g.Expect(metadata.WaitForIPv6).To(gomega.BeTrue())
+	g.Expect(metadata.Routes).To(gomega.Equal(machine.Spec.Network.Routes))
+	g.Expect(metadata.Devices).To(gomega.HaveLen(2))
+	g.Expect(metadata.Devices[0].MACAddr).To(gomega.Equal("00:00:00:00:00"))
+	g.Expect(metadata.Devices[0].IPAddrs).To(gomega.Equal([]string{"192.168.4.21"}))
+	g.Expect(metadata.Devices[1].MACAddr).To(gomega.Equal("00:00:00:00:ff"))
+	g.Expect(metadata.Devices[1].DHCP6).To(gomega.BeTrue())
+
+	// The machine's devices must not be modified.
+	g.Expect(machine.Spec.Network.Devices[0].MACAddr).To(gomega.BeEmpty())
+}
+
+func Test_GetMachineMetadata_DHCPMACAddress(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	machine := v1alpha3.VSphereVM{
+		Spec: v1alpha3.VSphereVMSpec{
+			VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+				Network: v1alpha3.NetworkSpec{
+					Devices: []v1alpha3.NetworkDeviceSpec{
+						{
+							NetworkName: "network1",
+							MACAddr:     "00:50:56:00:00:01",
+							DHCP4:       true,
+						},
+						{
+							NetworkName: "network2",
+							DHCP4:       true,
+						},
+					},
+				},
+			},
+		},
+	}
+	// The first NIC's MAC address has not been reported yet.
+	networkStatus := []v1alpha3.NetworkStatus{
+		{},
+		{MACAddr: "00:50:56:00:00:02"},
+	}
+
+	metadata, err := util.GetMachineMetadata("test-vm", machine, networkStatus...)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(string(metadata)).To(gomega.ContainSubstring(`
+    id0:
+      match:
+        macaddress: "00:50:56:00:00:01"
+      set-name: "eth0"
+      wakeonlan: true
+      dhcp4: true`))
+	g.Expect(string(metadata)).To(gomega.ContainSubstring(`
+    id1:
+      match:
+        macaddress: "00:50:56:00:00:02"
+      set-name: "eth1"
+      wakeonlan: true
+      dhcp4: true`))
+}
+
+func Test_GetMachineMetadata_IPv6Only(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	machine := v1alpha3.VSphereVM{
+		Spec: v1alpha3.VSphereVMSpec{
+			VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+				Network: v1alpha3.NetworkSpec{
+					Devices: []v1alpha3.NetworkDeviceSpec{
+						{
+							NetworkName: "network1",
+							MACAddr:     "00:00:00:00:00",
+							DHCP6:       true,
+							IPAddrs:     []string{"fd00:1::21/64"},
+							Gateway6:    "fd00:1::1",
+							Nameservers: []string{"fd00:53::1"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	metadata, err := util.GetMachineMetadata("test-vm", machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(string(metadata)).To(gomega.Equal(`
+instance-id: "test-vm"
+local-hostname: "test-vm"
+wait-on-network:
+  ipv4: false
+  ipv6: true
+network:
+  version: 2
+  ethernets:
+    id0:
+      match:
+        macaddress: "00:00:00:00:00"
+      set-name: "eth0"
+      wakeonlan: true
+      dhcp6: true
+      addresses:
+      - "fd00:1::21/64"
+      gateway6: "fd00:1::1"
+      nameservers:
+        addresses:
+        - "fd00:53::1"
+`))
+	g.Expect(string(metadata)).NotTo(gomega.ContainSubstring("dhcp4"))
+	g.Expect(string(metadata)).NotTo(gomega.ContainSubstring("gateway4"))
+}
+
+func Test_GetMachineMetadata_InstanceID(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	machine := v1alpha3.VSphereVM{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-vm-abcde",
+		},
+		Spec: v1alpha3.VSphereVMSpec{
+			VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+				Network: v1alpha3.NetworkSpec{
+					Devices: []v1alpha3.NetworkDeviceSpec{
+						{
+							NetworkName: "network1",
+							DHCP4:       true,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// The first render happens before the clone, the second once the VM
+	// has a BIOS UUID and its NIC has a MAC address.
+	first, err := util.GetMachineMetadata("test-vm", machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	machine.Spec.BiosUUID = "265104de-1472-547c-b873-6dc7883fb6cb"
+	second, err := util.GetMachineMetadata("test-vm", machine, v1alpha3.NetworkStatus{MACAddr: "00:00:00:00:00"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(string(first)).To(gomega.HavePrefix("\ninstance-id: \"test-vm-abcde\"\n"))
+	g.Expect(string(second)).To(gomega.HavePrefix("\ninstance-id: \"test-vm-abcde\"\n"))
+}
+
+func Test_GetMachineMetadata_AddressFamilyPreference(t *testing.T) {
+	testCases := []struct {
+		name       string
+		preference v1alpha3.AddressFamily
+		expected   string
+	}{
+		{
+			name:       "ipv4 first",
+			preference: v1alpha3.AddressFamilyIPv4,
+			expected: `
+      addresses:
+      - "192.168.4.21/24"
+      - "192.168.4.22/24"
+      - "fd00:1::21/64"
+      gateway4: "192.168.4.1"
+      gateway6: "fd00:1::1"
+      nameservers:
+        addresses:
+        - "1.1.1.1"
+        - "8.8.8.8"
+        - "fd00:53::1"
+  routes:
+  - to: "0.0.0.0/0"
+    via: "192.168.4.254"
+    metric: 100
+  - to: "10.0.0.0/8"
+    via: "192.168.4.253"
+    metric: 50
+  - to: "::/0"
+    via: "fd00:1::254"
+    metric: 100
+`,
+		},
+		{
+			name:       "ipv6 first",
+			preference: v1alpha3.AddressFamilyIPv6,
+			expected: `
+      addresses:
+      - "fd00:1::21/64"
+      - "192.168.4.21/24"
+      - "192.168.4.22/24"
+      gateway4: "192.168.4.1"
+      gateway6: "fd00:1::1"
+      nameservers:
+        addresses:
+        - "fd00:53::1"
+        - "1.1.1.1"
+        - "8.8.8.8"
+  routes:
+  - to: "::/0"
+    via: "fd00:1::254"
+    metric: 100
+  - to: "0.0.0.0/0"
+    via: "192.168.4.254"
+    metric: 100
+  - to: "10.0.0.0/8"
+    via: "192.168.4.253"
+    metric: 50
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+
+			// The families are interleaved so that neither preference
+			// matches the order of the spec.
+			machine := v1alpha3.VSphereVM{
+				Spec: v1alpha3.VSphereVMSpec{
+					VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+						Network: v1alpha3.NetworkSpec{
+							AddressFamilyPreference: tc.preference,
+							Devices: []v1alpha3.NetworkDeviceSpec{
+								{
+									NetworkName: "network1",
+									MACAddr:     "00:00:00:00:00",
+									IPAddrs:     []string{"192.168.4.21/24", "fd00:1::21/64", "192.168.4.22/24"},
+									Gateway4:    "192.168.4.1",
+									Gateway6:    "fd00:1::1",
+									Nameservers: []string{"1.1.1.1", "fd00:53::1", "8.8.8.8"},
+								},
+							},
+							Routes: []v1alpha3.NetworkRouteSpec{
+								{To: "0.0.0.0/0", Via: "192.168.4.254", Metric: 100},
+								{To: "::/0", Via: "fd00:1::254", Metric: 100},
+								{To: "10.0.0.0/8", Via: "192.168.4.253", Metric: 50},
+							},
+						},
+					},
+				},
+			}
+
+			metadata, err := util.GetMachineMetadata("test-vm", machine)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(string(metadata)).To(gomega.HaveSuffix(tc.expected))
+			g.Expect(machine.Spec.Network.Devices[0].IPAddrs[0]).To(gomega.Equal("192.168.4.21/24"))
+			g.Expect(machine.Spec.Network.Routes[0].To).To(gomega.Equal("0.0.0.0/0"))
+		})
+	}
+}
+
+func Test_GetMachineMetadata_Bond(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mode     v1alpha3.BondMode
+		expected string
+	}{
+		{
+			name: "802.3ad",
+			mode: v1alpha3.BondMode8023AD,
+			expected: `
+instance-id: "test-vm"
+local-hostname: "test-vm"
+wait-on-network:
+  ipv4: true
+  ipv6: false
+network:
+  version: 2
+  ethernets:
+    id0:
+      match:
+        macaddress: "00:00:00:00:00"
+      set-name: "eth0"
+      wakeonlan: true
+      dhcp4: true
+      dhcp6: false
+    id1:
+      match:
+        macaddress: "00:00:00:00:01"
+      set-name: "storage0"
+      wakeonlan: true
+      mtu: 9000
+    id2:
+      match:
+        macaddress: "00:00:00:00:02"
+      set-name: "storage1"
+      wakeonlan: true
+      mtu: 9000
+  bonds:
+    "bond0":
+      interfaces:
+      - id1
+      - id2
+      parameters:
+        mode: "802.3ad"
+        mii-monitor-interval: 100
+      addresses:
+      - "10.10.0.21/24"
+      mtu: 9000
+`,
+		},
+		{
+			name: "active-backup",
+			mode: v1alpha3.BondModeActiveBackup,
+			expected: `
+instance-id: "test-vm"
+local-hostname: "test-vm"
+wait-on-network:
+  ipv4: true
+  ipv6: false
+network:
+  version: 2
+  ethernets:
+    id0:
+      match:
+        macaddress: "00:00:00:00:00"
+      set-name: "eth0"
+      wakeonlan: true
+      dhcp4: true
+      dhcp6: false
+    id1:
+      match:
+        macaddress: "00:00:00:00:01"
+      set-name: "storage0"
+      wakeonlan: true
+      mtu: 9000
+    id2:
+      match:
+        macaddress: "00:00:00:00:02"
+      set-name: "storage1"
+      wakeonlan: true
+      mtu: 9000
+  bonds:
+    "bond0":
+      interfaces:
+      - id1
+      - id2
+      parameters:
+        mode: "active-backup"
+        mii-monitor-interval: 100
+        primary: id1
+      addresses:
+      - "10.10.0.21/24"
+      mtu: 9000
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+
+			mtu := int64(9000)
+			machine := v1alpha3.VSphereVM{
+				Spec: v1alpha3.VSphereVMSpec{
+					VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+						Network: v1alpha3.NetworkSpec{
+							Devices: []v1alpha3.NetworkDeviceSpec{
+								{
+									NetworkName: "network1",
+									MACAddr:     "00:00:00:00:00",
+									DHCP4:       true,
+								},
+								{
+									NetworkName: "storage",
+									MACAddr:     "00:00:00:00:01",
+									DeviceName:  "storage0",
+									IPAddrs:     []string{"10.10.0.21/24"},
+									MTU:         &mtu,
+								},
+								{
+									NetworkName: "storage",
+									MACAddr:     "00:00:00:00:02",
+									DeviceName:  "storage1",
+									MTU:         &mtu,
+								},
+							},
+							Bonds: []v1alpha3.NetworkBondSpec{
+								{
+									Name:    "bond0",
+									Mode:    tc.mode,
+									Members: []string{"storage0", "storage1"},
+								},
+							},
+						},
+					},
+				},
+			}
+
+			metadata, err := util.GetMachineMetadata("test-vm", machine)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(string(metadata)).To(gomega.Equal(tc.expected))
+		})
+	}
+}
+
+func Test_GetMachineMetadata_BondInvalid(t *testing.T) {
+	testCases := []struct {
+		name  string
+		bonds []v1alpha3.NetworkBondSpec
+		err   string
+	}{
+		{
+			name:  "unknown member",
+			bonds: []v1alpha3.NetworkBondSpec{{Name: "bond0", Mode: v1alpha3.BondMode8023AD, Members: []string{"eth0", "eth2"}}},
+			err:   `bond "bond0" member "eth2" is not a network device`,
+		},
+		{
+			name: "shared member",
+			bonds: []v1alpha3.NetworkBondSpec{
+				{Name: "bond0", Mode: v1alpha3.BondMode8023AD, Members: []string{"eth0"}},
+				{Name: "bond1", Mode: v1alpha3.BondMode8023AD, Members: []string{"eth0"}},
+			},
+			err: `bond "bond1" member "eth0" is already a bond member`,
+		},
+		{
+			name:  "addresses on a secondary member",
+			bonds: []v1alpha3.NetworkBondSpec{{Name: "bond0", Mode: v1alpha3.BondModeActiveBackup, Members: []string{"eth1", "eth0"}}},
+			err:   `bond "bond0" member "eth0" may not have addresses`,
+		},
+		{
+			name:  "unsupported mode",
+			bonds: []v1alpha3.NetworkBondSpec{{Name: "bond0", Mode: "balance-rr", Members: []string{"eth0"}}},
+			err:   `bond "bond0" has unsupported mode "balance-rr"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+
+			machine := v1alpha3.VSphereVM{
+				Spec: v1alpha3.VSphereVMSpec{
+					VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+						Network: v1alpha3.NetworkSpec{
+							Devices: []v1alpha3.NetworkDeviceSpec{
+								{NetworkName: "network1", DHCP4: true},
+								{NetworkName: "network1"},
+							},
+							Bonds: tc.bonds,
+						},
+					},
+				},
+			}
+
+			_, err := util.GetMachineMetadata("test-vm", machine)
+			g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(tc.err)))
+		})
+	}
+}
+
+func Test_GetMachineMetadata_DedupNameservers(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	machine := v1alpha3.VSphereVM{
+		Spec: v1alpha3.VSphereVMSpec{
+			VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+				Network: v1alpha3.NetworkSpec{
+					Devices: []v1alpha3.NetworkDeviceSpec{
+						{
+							NetworkName:   "network1",
+							MACAddr:       "00:00:00:00:00",
+							DHCP4:         true,
+							Nameservers:   []string{"8.8.8.8", "1.1.1.1", "8.8.8.8", "fd00:53::1", "FD00:53:0::1", "1.1.1.1"},
+							SearchDomains: []string{"site.example.com", "example.com", "Site.Example.com.", "example.com"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	metadata, err := util.GetMachineMetadata("test-vm", machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(string(metadata)).To(gomega.HaveSuffix(`
+      nameservers:
+        addresses:
+        - "8.8.8.8"
+        - "1.1.1.1"
+        - "fd00:53::1"
+        search:
+        - "site.example.com"
+        - "example.com"
+`))
+	g.Expect(machine.Spec.Network.Devices[0].Nameservers).To(gomega.HaveLen(6))
+}
+
+func Test_GetMachineMetadata_DefaultSearchDomains(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	machine := v1alpha3.VSphereVM{
+		Spec: v1alpha3.VSphereVMSpec{
+			VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+				Network: v1alpha3.NetworkSpec{
+					DefaultSearchDomains: []string{"cluster.example.com", "example.com"},
+					Devices: []v1alpha3.NetworkDeviceSpec{
+						{
+							NetworkName: "network1",
+							MACAddr:     "00:00:00:00:00",
+							DHCP4:       true,
+						},
+						{
+							NetworkName:   "network2",
+							MACAddr:       "00:00:00:00:01",
+							DHCP4:         true,
+							SearchDomains: []string{"storage.example.com"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	metadata, err := util.GetMachineMetadata("test-vm", machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(string(metadata)).To(gomega.HaveSuffix(`
+  ethernets:
+    id0:
+      match:
+        macaddress: "00:00:00:00:00"
+      set-name: "eth0"
+      wakeonlan: true
+      dhcp4: true
+      dhcp6: false
+      nameservers:
+        search:
+        - "cluster.example.com"
+        - "example.com"
+    id1:
+      match:
+        macaddress: "00:00:00:00:01"
+      set-name: "eth1"
+      wakeonlan: true
+      dhcp4: true
+      dhcp6: false
+      nameservers:
+        search:
+        - "storage.example.com"
+`))
+	g.Expect(machine.Spec.Network.Devices[0].SearchDomains).To(gomega.BeEmpty())
+}
+
+func Test_GetMachineMetadata_VLAN(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	vlan := 100
+	mtu := int64(9000)
+	machine := v1alpha3.VSphereVM{
+		Spec: v1alpha3.VSphereVMSpec{
+			VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+				Network: v1alpha3.NetworkSpec{
+					Devices: []v1alpha3.NetworkDeviceSpec{
+						{
+							NetworkName: "network1",
+							MACAddr:     "00:00:00:00:00",
+							DeviceName:  "ens192",
+							VLAN:        &vlan,
+							MTU:         &mtu,
+							IPAddrs:     []string{"192.168.4.21/24"},
+							Gateway4:    "192.168.4.1",
+							Nameservers: []string{"1.1.1.1"},
+						},
+						{
+							NetworkName: "network2",
+							MACAddr:     "00:00:00:00:01",
+							DHCP4:       true,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	metadata, err := util.GetMachineMetadata("test-vm", machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(string(metadata)).To(gomega.HaveSuffix(`
+network:
+  version: 2
+  ethernets:
+    id0:
+      match:
+        macaddress: "00:00:00:00:00"
+      set-name: "ens192"
+      wakeonlan: true
+      mtu: 9000
+    id1:
+      match:
+        macaddress: "00:00:00:00:01"
+      set-name: "eth1"
+      wakeonlan: true
+      dhcp4: true
+      dhcp6: false
+  vlans:
+    "ens192.100":
+      id: 100
+      link: id0
+      addresses:
+      - "192.168.4.21/24"
+      gateway4: "192.168.4.1"
+      mtu: 9000
+      nameservers:
+        addresses:
+        - "1.1.1.1"
+`))
+
+	vlan = 4095
+	_, err = util.GetMachineMetadata("test-vm", machine)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func Test_GetMachineMetadata_AnnotationMetadata(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	machine := v1alpha3.VSphereVM{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				constants.MetadataAnnotationPrefix + "availability-zone": "zone-a",
+				constants.MetadataAnnotationPrefix + "instance-id":       "overridden",
+				constants.MetadataAnnotationPrefix + "network":           "overridden",
+				"unrelated": "ignored",
+			},
+		},
+		Spec: v1alpha3.VSphereVMSpec{
+			VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+				Network: v1alpha3.NetworkSpec{
+					Devices: []v1alpha3.NetworkDeviceSpec{
+						{
+							NetworkName: "network1",
+							MACAddr:     "00:00:00:00:00",
+							DHCP4:       true,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	metadata, err := util.GetMachineMetadata("test-vm", machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(string(metadata)).To(gomega.HavePrefix(`
+instance-id: "test-vm"
+local-hostname: "test-vm"
+`))
+	g.Expect(string(metadata)).To(gomega.HaveSuffix(`
+"availability-zone": "zone-a"
+`))
+	g.Expect(string(metadata)).NotTo(gomega.ContainSubstring("overridden"))
+	g.Expect(string(metadata)).NotTo(gomega.ContainSubstring("unrelated"))
+}
+
+func Test_BuildMachineMetadata_SkipWaitOnNetwork(t *testing.T) {
+	devices := []v1alpha3.NetworkDeviceSpec{
+		{
+			NetworkName: "network1",
+			IPAddrs:     []string{"192.168.4.21"},
+		},
+		{
+			NetworkName: "network2",
+			DHCP6:       true,
+		},
+	}
+
+	testCases := []struct {
+		name                string
+		skipWaitOnNetwork   bool
+		expectedWaitForIPv4 bool
+		expectedWaitForIPv6 bool
+	}{
+		{
+			name:                "wait by default",
+			expectedWaitForIPv4: true,
+			expectedWaitForIPv6: true,
+		},
+		{
+			name:                "skip wait",
+			skipWaitOnNetwork:   true,
+			expectedWaitForIPv4: false,
+			expectedWaitForIPv6: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+			machine := v1alpha3.VSphereVM{
+				Spec: v1alpha3.VSphereVMSpec{
+					VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+						Network: v1alpha3.NetworkSpec{
+							Devices:           devices,
+							SkipWaitOnNetwork: tc.skipWaitOnNetwork,
+						},
+					},
+				},
+			}
+
+			metadata, err := util.BuildMachineMetadata("test-vm", machine)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(metadata.WaitForIPv4).To(gomega.Equal(tc.expectedWaitForIPv4))
+			g.Expect(metadata.WaitForIPv6).To(gomega.Equal(tc.expectedWaitForIPv6))
+
+			rendered, err := util.GetMachineMetadata("test-vm", machine)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(string(rendered)).To(gomega.ContainSubstring(fmt.Sprintf(
+				"wait-on-network:\n  ipv4: %t\n  ipv6: %t\n", tc.expectedWaitForIPv4, tc.expectedWaitForIPv6)))
+		})
+	}
+}
+
+func Test_GetMachineMetadataWithTemplate(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	machine := v1alpha3.VSphereVM{
+		Spec: v1alpha3.VSphereVMSpec{
+			VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+				Network: v1alpha3.NetworkSpec{
+					Domain: "example.com",
+					Devices: []v1alpha3.NetworkDeviceSpec{
+						{
+							NetworkName: "network1",
+							DHCP4:       true,
+							Nameservers: []string{"8.8.8.8"},
+						},
+					},
+				},
+			},
+		},
+	}
+	networkStatus := v1alpha3.NetworkStatus{MACAddr: "00:00:00:00:00:01"}
+
+	const tmpl = `{{ .Hostname }}.{{ .Domain }}{{ range .Devices }} {{ .MACAddr }} {{ nameservers . }}{{ end }} {{ .WaitForIPv4 }}`
+	metadata, err := util.GetMachineMetadataWithTemplate(tmpl, "test-vm", machine, networkStatus)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(string(metadata)).To(gomega.Equal("test-vm.example.com 00:00:00:00:00:01 true true"))
+
+	_, err = util.GetMachineMetadataWithTemplate("{{ .Hostname", "test-vm", machine)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func Test_GetMachineMetadata_GatewayValidation(t *testing.T) {
+	testCases := []struct {
+		name          string
+		device        v1alpha3.NetworkDeviceSpec
+		expectedError string
+	}{
+		{
+			name: "valid gateways",
+			device: v1alpha3.NetworkDeviceSpec{
+				NetworkName: "network1",
+				IPAddrs:     []string{"192.168.4.21/24", "fd00::21/64"},
+				Gateway4:    "192.168.4.1",
+				Gateway6:    "fd00::1",
+			},
+		},
+		{
+			name: "gateway without CIDR addresses",
+			device: v1alpha3.NetworkDeviceSpec{
+				NetworkName: "network1",
+				DHCP6:       true,
+				IPAddrs:     []string{"192.168.4.21"},
+				Gateway4:    "10.0.0.1",
+			},
+		},
+		{
+			name: "malformed gateway4",
+			device: v1alpha3.NetworkDeviceSpec{
+				NetworkName: "network1",
+				IPAddrs:     []string{"192.168.4.21/24"},
+				Gateway4:    "192.168.4.300",
+			},
+			expectedError: `gateway4 "192.168.4.300" is not a valid IP address`,
+		},
+		{
+			name: "IPv6 gateway4",
+			device: v1alpha3.NetworkDeviceSpec{
+				NetworkName: "network1",
+				IPAddrs:     []string{"192.168.4.21/24"},
+				Gateway4:    "fd00::1",
+			},
+			expectedError: `gateway4 "fd00::1" is not a valid IP address`,
+		},
+		{
+			name: "out-of-subnet gateway4",
+			device: v1alpha3.NetworkDeviceSpec{
+				NetworkName: "network1",
+				IPAddrs:     []string{"192.168.4.21/24"},
+				Gateway4:    "192.168.5.1",
+			},
+			expectedError: `gateway4 "192.168.5.1" is not in the subnet of any of the device's addresses`,
+		},
+		{
+			name: "out-of-subnet gateway6",
+			device: v1alpha3.NetworkDeviceSpec{
+				NetworkName: "network1",
+				IPAddrs:     []string{"192.168.4.21/24", "fd00::21/64"},
+				Gateway6:    "fd01::1",
+			},
+			expectedError: `gateway6 "fd01::1" is not in the subnet of any of the device's addresses`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+			machine := v1alpha3.VSphereVM{
+				Spec: v1alpha3.VSphereVMSpec{
+					VirtualMachineCloneSpec: v1alpha3.VirtualMachineCloneSpec{
+						Network: v1alpha3.NetworkSpec{
+							Devices: []v1alpha3.NetworkDeviceSpec{tc.device},
+						},
+					},
+				},
+			}
+			_, err := util.GetMachineMetadata("test-vm", machine)
+			if tc.expectedError == "" {
+				g.Expect(err).NotTo(gomega.HaveOccurred())
+				return
+			}
+			g.Expect(err).To(gomega.HaveOccurred())
+			g.Expect(err.Error()).To(gomega.ContainSubstring(tc.expectedError))
+		})
+	}
+}
+
+func TestIsControlPlaneMachine(t *testing.T) {
+	kcpOwnerRef := metav1.OwnerReference{
+		APIVersion: "controlplane.cluster.x-k8s.io/v1alpha3",
+		Kind:       "KubeadmControlPlane",
+		Name:       "kcp",
+	}
+	testCases := []struct {
+		name      string
+		labels    map[string]string
+		ownerRefs []metav1.OwnerReference
+		expected  bool
+	}{
+		{
+			name:     "no label or owner",
+			expected: false,
+		},
+		{
+			name:     "label only",
+			labels:   map[string]string{clusterv1.MachineControlPlaneLabelName: ""},
+			expected: true,
+		},
+		{
+			name:      "owner only",
+			ownerRefs: []metav1.OwnerReference{kcpOwnerRef},
+			expected:  true,
+		},
+		{
+			name:      "label and owner",
+			labels:    map[string]string{clusterv1.MachineControlPlaneLabelName: ""},
+			ownerRefs: []metav1.OwnerReference{kcpOwnerRef},
+			expected:  true,
+		},
+		{
+			name: "non control plane owner",
+			ownerRefs: []metav1.OwnerReference{
+				{
+					APIVersion: "cluster.x-k8s.io/v1alpha3",
+					Kind:       "MachineSet",
+					Name:       "ms",
+				},
+			},
+			expected: false,
+		},
+		{
+			name: "owner with the same kind in another group",
+			ownerRefs: []metav1.OwnerReference{
+				{
+					APIVersion: "example.com/v1",
+					Kind:       "KubeadmControlPlane",
+					Name:       "kcp",
+				},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			machine := &clusterv1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:          tc.labels,
+					OwnerReferences: tc.ownerRefs,
+				},
+			}
+			if actual := util.IsControlPlaneMachine(machine); actual != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestConvertProviderIDToUUID(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	testCases := []struct {
+		name         string
+		providerID   *string
+		expectedUUID string
+	}{
+		{
+			name:         "nil providerID",
+			providerID:   nil,
+			expectedUUID: "",
+		},
+		{
+			name:         "empty providerID",
+			providerID:   toStringPtr(""),
+			expectedUUID: "",
+		},
+		{
+			name:         "invalid providerID",
+			providerID:   toStringPtr("1234"),
+			expectedUUID: "",
+		},
+		{
+			name:         "missing prefix",
+			providerID:   toStringPtr("12345678-1234-1234-1234-123456789abc"),
+			expectedUUID: "",
+		},
+		{
+			name:         "valid providerID",
+			providerID:   toStringPtr("vsphere://12345678-1234-1234-1234-123456789abc"),
+			expectedUUID: "12345678-1234-1234-1234-123456789abc",
+		},
+		{
+			name:         "mixed case",
+			providerID:   toStringPtr("vsphere://12345678-1234-1234-1234-123456789AbC"),
+			expectedUUID: "12345678-1234-1234-1234-123456789abc",
+		},
+		{
+			name:         "uppercase prefix and UUID",
+			providerID:   toStringPtr("VSPHERE://12345678-ABCD-ABCD-ABCD-123456789ABC"),
+			expectedUUID: "12345678-abcd-abcd-abcd-123456789abc",
+		},
+		{
+			name:         "surrounding whitespace",
+			providerID:   toStringPtr("  vsphere://12345678-1234-1234-1234-123456789AbC\n"),
+			expectedUUID: "12345678-1234-1234-1234-123456789abc",
+		},
+		{
+			name:         "only whitespace",
+			providerID:   toStringPtr(" \t\n"),
+			expectedUUID: "",
 		},
 		{
 			name:         "invalid hex chars",
 			providerID:   toStringPtr("vsphere://12345678-1234-1234-1234-123456789abg"),
 			expectedUUID: "",
 		},
+		{
+			name:         "braced UUID",
+			providerID:   toStringPtr("vsphere://{12345678-1234-1234-1234-123456789AbC}"),
+			expectedUUID: "12345678-1234-1234-1234-123456789abc",
+		},
+		{
+			name:         "unpaired brace",
+			providerID:   toStringPtr("vsphere://{12345678-1234-1234-1234-123456789abc"),
+			expectedUUID: "",
+		},
+		{
+			name:         "braces only",
+			providerID:   toStringPtr("vsphere://{}"),
+			expectedUUID: "",
+		},
 	}
 	for _, tc := range testCases {
 		tc := tc
@@ -658,6 +2252,38 @@ func TestConvertProviderIDToUUID(t *testing.T) {
 	}
 }
 
+func TestConvertProviderIDsToUUIDs(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	providerIDs := []*string{
+		toStringPtr("vsphere://12345678-1234-1234-1234-123456789abc"),
+		nil,
+		toStringPtr("1234"),
+		toStringPtr("VSPHERE://12345678-ABCD-ABCD-ABCD-123456789ABC"),
+		toStringPtr("vsphere://{12345678-1234-1234-1234-123456789def}"),
+	}
+	g.Expect(util.ConvertProviderIDsToUUIDs(providerIDs)).To(gomega.Equal([]string{
+		"12345678-1234-1234-1234-123456789abc",
+		"",
+		"",
+		"12345678-abcd-abcd-abcd-123456789abc",
+		"12345678-1234-1234-1234-123456789def",
+	}))
+	g.Expect(util.ConvertProviderIDsToUUIDs(nil)).To(gomega.BeEmpty())
+}
+
+func BenchmarkConvertProviderIDsToUUIDs(b *testing.B) {
+	providerIDs := make([]*string, 100)
+	for i := range providerIDs {
+		providerIDs[i] = toStringPtr(fmt.Sprintf("vsphere://12345678-1234-1234-1234-%012x", i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		util.ConvertProviderIDsToUUIDs(providerIDs)
+	}
+}
+
 func TestConvertUUIDtoProviderID(t *testing.T) {
 	g := gomega.NewGomegaWithT(t)
 
@@ -691,6 +2317,21 @@ func TestConvertUUIDtoProviderID(t *testing.T) {
 			uuid:               "12345678-1234-1234-1234-123456789abg",
 			expectedProviderID: "",
 		},
+		{
+			name:               "braced uuid",
+			uuid:               "{12345678-1234-1234-1234-123456789abc}",
+			expectedProviderID: "vsphere://12345678-1234-1234-1234-123456789abc",
+		},
+		{
+			name:               "unpaired brace",
+			uuid:               "12345678-1234-1234-1234-123456789abc}",
+			expectedProviderID: "",
+		},
+		{
+			name:               "braced garbage",
+			uuid:               "{not-a-uuid}",
+			expectedProviderID: "",
+		},
 	}
 	for _, tc := range testCases {
 		tc := tc
@@ -701,6 +2342,168 @@ func TestConvertUUIDtoProviderID(t *testing.T) {
 	}
 }
 
+func TestConvertInstanceUUIDToProviderID(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	testCases := []struct {
+		name               string
+		uuid               string
+		expectedProviderID string
+	}{
+		{
+			name:               "empty uuid",
+			uuid:               "",
+			expectedProviderID: "",
+		},
+		{
+			name:               "invalid uuid",
+			uuid:               "1234",
+			expectedProviderID: "",
+		},
+		{
+			name:               "valid uuid",
+			uuid:               "12345678-1234-1234-1234-123456789abc",
+			expectedProviderID: "vsphere://instance/12345678-1234-1234-1234-123456789abc",
+		},
+		{
+			name:               "braced uuid",
+			uuid:               "{12345678-1234-1234-1234-123456789abc}",
+			expectedProviderID: "vsphere://instance/12345678-1234-1234-1234-123456789abc",
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			actualProviderID := util.ConvertInstanceUUIDToProviderID(tc.uuid)
+			g.Expect(actualProviderID).To(gomega.Equal(tc.expectedProviderID))
+		})
+	}
+}
+
+func TestParseProviderID(t *testing.T) {
+	testCases := []struct {
+		name         string
+		providerID   *string
+		expectedUUID string
+		expectedErr  error
+	}{
+		{
+			name:        "nil providerID",
+			providerID:  nil,
+			expectedErr: util.ErrProviderIDEmpty,
+		},
+		{
+			name:        "empty providerID",
+			providerID:  toStringPtr(""),
+			expectedErr: util.ErrProviderIDEmpty,
+		},
+		{
+			name:        "whitespace providerID",
+			providerID:  toStringPtr("  "),
+			expectedErr: util.ErrProviderIDEmpty,
+		},
+		{
+			name:        "malformed providerID",
+			providerID:  toStringPtr("vsphere://1234"),
+			expectedErr: util.ErrProviderIDMalformed,
+		},
+		{
+			name:        "instance UUID providerID",
+			providerID:  toStringPtr("vsphere://instance/12345678-1234-1234-1234-123456789abc"),
+			expectedErr: util.ErrProviderIDInstanceUUID,
+		},
+		{
+			name:         "valid providerID",
+			providerID:   toStringPtr("vsphere://12345678-1234-1234-1234-123456789ABC"),
+			expectedUUID: "12345678-1234-1234-1234-123456789abc",
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+
+			uuid, err := util.ParseProviderID(tc.providerID)
+			g.Expect(uuid).To(gomega.Equal(tc.expectedUUID))
+			if tc.expectedErr == nil {
+				g.Expect(err).NotTo(gomega.HaveOccurred())
+			} else {
+				g.Expect(errors.Is(err, tc.expectedErr)).To(gomega.BeTrue(), "expected %v, got %v", tc.expectedErr, err)
+			}
+			g.Expect(util.ConvertProviderIDToUUID(tc.providerID)).To(gomega.Equal(tc.expectedUUID))
+		})
+	}
+}
+
+func TestParseProviderIDKind(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		providerID           *string
+		expectedUUID         string
+		expectedInstanceUUID bool
+		expectedErr          error
+	}{
+		{
+			name:        "nil providerID",
+			providerID:  nil,
+			expectedErr: util.ErrProviderIDEmpty,
+		},
+		{
+			name:        "empty providerID",
+			providerID:  toStringPtr(""),
+			expectedErr: util.ErrProviderIDEmpty,
+		},
+		{
+			name:        "invalid providerID",
+			providerID:  toStringPtr("vsphere://instance/1234"),
+			expectedErr: util.ErrProviderIDMalformed,
+		},
+		{
+			name:         "BIOS UUID",
+			providerID:   toStringPtr("vsphere://12345678-1234-1234-1234-123456789abc"),
+			expectedUUID: "12345678-1234-1234-1234-123456789abc",
+		},
+		{
+			name:                 "instance UUID",
+			providerID:           toStringPtr("vsphere://instance/12345678-1234-1234-1234-123456789abc"),
+			expectedUUID:         "12345678-1234-1234-1234-123456789abc",
+			expectedInstanceUUID: true,
+		},
+		{
+			name:                 "mixed case instance UUID",
+			providerID:           toStringPtr("vsphere://Instance/12345678-1234-1234-1234-123456789AbC"),
+			expectedUUID:         "12345678-1234-1234-1234-123456789abc",
+			expectedInstanceUUID: true,
+		},
+		{
+			name:                 "braced instance UUID",
+			providerID:           toStringPtr(" vsphere://instance/{12345678-1234-1234-1234-123456789abc} "),
+			expectedUUID:         "12345678-1234-1234-1234-123456789abc",
+			expectedInstanceUUID: true,
+		},
+		{
+			name:        "braced garbage",
+			providerID:  toStringPtr("vsphere://instance/{1234}"),
+			expectedErr: util.ErrProviderIDMalformed,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+
+			uuid, instanceUUID, err := util.ParseProviderIDKind(tc.providerID)
+			g.Expect(uuid).To(gomega.Equal(tc.expectedUUID))
+			g.Expect(instanceUUID).To(gomega.Equal(tc.expectedInstanceUUID))
+			if tc.expectedErr == nil {
+				g.Expect(err).NotTo(gomega.HaveOccurred())
+			} else {
+				g.Expect(errors.Is(err, tc.expectedErr)).To(gomega.BeTrue(), "expected %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
 func mtu(i int64) *int64 {
 	if i == 0 {
 		return nil